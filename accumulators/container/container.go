@@ -7,6 +7,8 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"sync"
+	"time"
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/cfapps"
@@ -22,16 +24,72 @@ import (
 type Metrics struct {
 	accumulators.Accumulator
 	CFAppManager *cfapps.CFAppManager
+	cpuType      metrics.Type
+	absolute     *absoluteCPU
+}
+
+// absoluteCPU keeps the last absolute_usage and absolute_entitlement of every app instance.
+// Both are cumulative, so the entitlement used is the ratio of their increases between
+// successive envelopes.
+type absoluteCPU struct {
+	lock      *sync.Mutex
+	instances map[string]*absoluteSample
+}
+
+type absoluteSample struct {
+	usage       float64
+	entitlement float64
+	seen        time.Time
+}
+
+// absoluteExpiry of the instances that stopped sending the absolute gauges.
+const absoluteExpiry = 10 * time.Minute
+
+// entitlement used by the instance since its previous envelope in percent, false for the
+// first envelope of an instance and after the counters were reset.
+func (a *absoluteCPU) entitlement(instance string, usage float64, entitlement float64) (float64, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	last, found := a.instances[instance]
+	a.instances[instance] = &absoluteSample{usage: usage, entitlement: entitlement, seen: time.Now()}
+	if !found {
+		return 0, false
+	}
+	usageDelta, entitlementDelta := usage-last.usage, entitlement-last.entitlement
+	if usageDelta < 0 || entitlementDelta <= 0 {
+		return 0, false
+	}
+	return usageDelta / entitlementDelta * 100, true
+}
+
+// expire the instances not seen since before.
+func (a *absoluteCPU) expire(before time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for instance, last := range a.instances {
+		if last.seen.Before(before) {
+			delete(a.instances, instance)
+		}
+	}
 }
 
 // New satisfies metric.Accumulator
-func (m Metrics) New() accumulators.Interface {
+func (m Metrics) New(c *config.Config) accumulators.Interface {
 	i := Metrics{
 		Accumulator: accumulators.NewAccumulator(
+			c,
 			// This isn't a v2 envelope type, but the router will route matching Gauge envelopes here.
 			"ContainerMetric",
 		),
 		CFAppManager: cfapps.GetInstance(),
+		cpuType:      metrics.Types.Gauge,
+		absolute: &absoluteCPU{
+			lock:      &sync.Mutex{},
+			instances: map[string]*absoluteSample{},
+		},
+	}
+	if i.Config().GetBool("METRICS_DISTRIBUTION") {
+		i.cpuType = metrics.Types.Distribution
 	}
 	return i
 }
@@ -47,33 +105,130 @@ func (m Metrics) Update(e *loggregator_v2.Envelope) {
 
 	entity.Attributes().AppendAll(attrs)
 
-	entity.NewSample(
-		"app.cpu",
-		metrics.Types.Gauge,
-		"percent",
-		e.GetGauge().Metrics["cpu"].GetValue(),
-	).Done()
-
-	entity.NewSample(
-		"app.disk",
-		metrics.Types.Gauge,
-		"bytes",
-		e.GetGauge().Metrics["disk"].GetValue(),
-	).SetAttribute(
-		"app.disk.quota",
-		e.GetGauge().Metrics["disk_quota"].GetValue(),
-	).Done()
-
-	entity.NewSample(
-		"app.memory",
-		metrics.Types.Gauge,
-		"bytes",
-		e.GetGauge().Metrics["memory"].GetValue(),
-	).SetAttribute(
-		"app.memory.quota",
-		e.GetGauge().Metrics["memory_quota"].GetValue(),
-	).Done()
+	// Newer Diego versions send the container gauges in several envelopes,
+	// only the gauges present in this envelope are sampled.
+	g := e.GetGauge().GetMetrics()
 
+	if cpu, ok := g["cpu"]; ok {
+		entity.NewSample(
+			"app.cpu",
+			m.cpuType,
+			"percent",
+			cpu.GetValue(),
+		).Done()
+	}
+
+	// Quotas are only set when the envelope has them, newer Diego versions can send the
+	// usage and quota gauges separately.
+	if disk, ok := g["disk"]; ok {
+		s := entity.NewSample(
+			"app.disk",
+			metrics.Types.Gauge,
+			"bytes",
+			disk.GetValue(),
+		)
+		if quota := g["disk_quota"].GetValue(); quota > 0 {
+			s.SetAttribute("app.disk.quota", quota)
+		}
+		s.Done()
+	}
+
+	if memory, ok := g["memory"]; ok {
+		s := entity.NewSample(
+			"app.memory",
+			metrics.Types.Gauge,
+			"bytes",
+			memory.GetValue(),
+		)
+		if quota := g["memory_quota"].GetValue(); quota > 0 {
+			s.SetAttribute("app.memory.quota", quota)
+		}
+		s.Done()
+	}
+
+	// Percentage of the CPU entitlement used. Diego reports it as cpu_entitlement, older
+	// versions only send the cumulative absolute usage and entitlement, it is derived from
+	// their increase since the previous envelope of the instance.
+	usage, hasUsage := g["absolute_usage"]
+	entitlement, hasEntitlement := g["absolute_entitlement"]
+	if cpuEntitlement, ok := g["cpu_entitlement"]; ok {
+		entity.NewSample(
+			"app.cpu.entitlement",
+			m.cpuType,
+			"percent",
+			cpuEntitlement.GetValue(),
+		).Done()
+	} else if hasUsage && hasEntitlement {
+		if used, ok := m.absolute.entitlement(
+			e.GetSourceId()+"/"+e.GetInstanceId(),
+			usage.GetValue(),
+			entitlement.GetValue(),
+		); ok {
+			entity.NewSample(
+				"app.cpu.entitlement",
+				m.cpuType,
+				"percent",
+				used,
+			).Done()
+		}
+	}
+	if hasUsage {
+		entity.NewSample(
+			"app.cpu.absolute.usage",
+			metrics.Types.Gauge,
+			unit(usage, "nanoseconds"),
+			usage.GetValue(),
+		).Done()
+	}
+	if hasEntitlement {
+		entity.NewSample(
+			"app.cpu.absolute.entitlement",
+			metrics.Types.Gauge,
+			unit(entitlement, "nanoseconds"),
+			entitlement.GetValue(),
+		).Done()
+	}
+
+	if logRate, ok := g["log_rate"]; ok {
+		s := entity.NewSample(
+			"app.log.rate",
+			metrics.Types.Gauge,
+			unit(logRate, "B/s"),
+			logRate.GetValue(),
+		)
+		// A log rate limit of -1 is unlimited.
+		if limit := g["log_rate_limit"].GetValue(); limit > 0 {
+			s.SetAttribute("app.log.rate.quota", limit)
+		}
+		s.Done()
+	}
+
+	if age, ok := g["container_age"]; ok {
+		entity.NewSample(
+			"app.container.age",
+			metrics.Types.Gauge,
+			unit(age, "nanoseconds"),
+			age.GetValue(),
+		).Done()
+	}
+
+	if delay, ok := g["anomalous_delay"]; ok {
+		entity.NewSample(
+			"app.anomalous.delay",
+			metrics.Types.Gauge,
+			unit(delay, "nanoseconds"),
+			delay.GetValue(),
+		).Done()
+	}
+
+}
+
+// unit of the gauge, or def when the envelope doesn't set one.
+func unit(v *loggregator_v2.GaugeValue, def string) string {
+	if v.GetUnit() != "" {
+		return v.GetUnit()
+	}
+	return def
 }
 
 // HarvestMetrics ...
@@ -84,13 +239,11 @@ func (m Metrics) HarvestMetrics(
 
 ) {
 
-	if metric.Name != "app.cpu" {
-		percentUsedAttributeName := fmt.Sprintf("%s.used", metric.Name)
-		metric.
-			SetAttribute(
-				percentUsedAttributeName,
-				calculateUsed(metric),
-			)
+	// Metrics with a quota report the percentage of the quota used.
+	if metric.Attributes().Has(fmt.Sprintf("%s.quota", metric.Name)) != nil {
+		if used, ok := calculateUsed(metric); ok {
+			metric.SetAttribute(fmt.Sprintf("%s.used", metric.Name), used)
+		}
 	}
 
 	metric.SetAttribute(
@@ -105,16 +258,26 @@ func (m Metrics) HarvestMetrics(
 
 	// Get a client for this metric - checking for insert key and account ID info in the application
 	// We will default to what is in the configuration file	if application specific info isn't found
-	client := nrpcf.GetInsertClientForApp(entity)
+	client := nrpcf.GetInsertClientForApp(entity, m.Config())
 	client.EnqueueEvent(context.Background(), metric.Marshal())
 
 }
 
-func calculateUsed(metric *metrics.Metric) float64 {
+// calculateUsed percentage of the quota, false without a positive quota.
+func calculateUsed(metric *metrics.Metric) (float64, bool) {
 	quotaAttributeName := fmt.Sprintf("%s.quota", metric.Name)
 	bytesUsed := metric.LastValue
 	bytesQuota := metric.Attributes().FloatValueOf(quotaAttributeName)
-	return (bytesUsed / bytesQuota) * 100
+	if bytesQuota <= 0 {
+		return 0, false
+	}
+	return (bytesUsed / bytesQuota) * 100, true
+}
+
+// Drain the entities, forgetting the instances that stopped sending absolute gauges.
+func (m Metrics) Drain() []*entities.Entity {
+	m.absolute.expire(time.Now().Add(-absoluteExpiry))
+	return m.Accumulator.Drain()
 }
 
 // GetTag ...
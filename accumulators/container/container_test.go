@@ -0,0 +1,59 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package container
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAbsoluteEntitlement(t *testing.T) {
+	a := &absoluteCPU{lock: &sync.Mutex{}, instances: map[string]*absoluteSample{}}
+
+	// The counters are cumulative, the first envelope of an instance has no increase.
+	_, ok := a.entitlement("app/0", 1000, 4000)
+	assert.False(t, ok)
+	used, ok := a.entitlement("app/0", 1500, 5000)
+	assert.True(t, ok)
+	assert.Equal(t, 50.0, used)
+	used, ok = a.entitlement("app/0", 3500, 6000)
+	assert.True(t, ok)
+	assert.Equal(t, 200.0, used)
+
+	// Instances are tracked separately.
+	_, ok = a.entitlement("app/1", 3500, 6000)
+	assert.False(t, ok)
+
+	// A restarted container resets its counters.
+	_, ok = a.entitlement("app/0", 100, 200)
+	assert.False(t, ok)
+	_, ok = a.entitlement("app/0", 200, 200)
+	assert.False(t, ok)
+	used, ok = a.entitlement("app/0", 300, 600)
+	assert.True(t, ok)
+	assert.Equal(t, 25.0, used)
+
+	a.expire(time.Now().Add(time.Second))
+	assert.Empty(t, a.instances)
+}
+
+func TestCalculateUsed(t *testing.T) {
+	m := metrics.New("app.memory", metrics.Types.Gauge, "bytes", 256, attributes.NewAttributes())
+	_, ok := calculateUsed(m)
+	assert.False(t, ok)
+
+	m.SetAttribute("app.memory.quota", float64(0))
+	_, ok = calculateUsed(m)
+	assert.False(t, ok)
+
+	m.SetAttribute("app.memory.quota", float64(1024))
+	used, ok := calculateUsed(m)
+	assert.True(t, ok)
+	assert.Equal(t, 25.0, used)
+}
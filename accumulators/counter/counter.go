@@ -5,6 +5,10 @@ package counter
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
@@ -14,37 +18,57 @@ import (
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/metrics"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrclients"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrpcf"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/uid"
 )
 
 // Metrics extends metric.Accumulator for
 // Firehose ContainerMetric Envelope Event Types
 type Metrics struct {
 	accumulators.Accumulator
+	counters *counters
+	filter   *accumulators.MetricFilter
 }
 
 // New satisfies metric.Accumulator
-func (m Metrics) New() accumulators.Interface {
+func (m Metrics) New(c *config.Config) accumulators.Interface {
 	i := Metrics{
 		Accumulator: accumulators.NewAccumulator(
+			c,
 			"*loggregator_v2.Envelope_Counter",
 		),
 	}
+	i.counters = newCounters(time.Duration(i.Config().GetInt("COUNTER_GAP_SECS")) * time.Second)
+	filter, err := accumulators.NewMetricFilter(
+		i.Config().GetString("COUNTEREVENT_INCLUDE"),
+		i.Config().GetString("COUNTEREVENT_EXCLUDE"),
+	)
+	if err != nil {
+		app.Get().Log.Fatalf("invalid CounterEvent filter: %s", err.Error())
+	}
+	i.filter = filter
 	return i
 }
 
 // Update satisfies metric.Accumulator
 func (m Metrics) Update(e *loggregator_v2.Envelope) {
-	m.GetEntity(e, nrpcf.GetPCFAttributes(e)).
+	if !m.filter.Allows(e, e.GetCounter().GetName()) {
+		return
+	}
+	entity := m.GetEntity(e, nrpcf.GetPCFAttributes(e))
+	entity.
 		NewSample(
 			e.GetCounter().Name,
 			metrics.Types.Delta, "delta",
 			float64(e.GetCounter().GetDelta()),
 		).
-		SetAttribute("total.reported",
-			e.GetCounter().GetTotal(),
-		).
 		Done()
 
+	m.counters.observe(
+		counterID(entity, e.GetCounter().Name),
+		series(e),
+		e.GetCounter().GetTotal(),
+		e.GetTimestamp(),
+	)
 }
 
 // HarvestMetrics ...
@@ -55,6 +79,20 @@ func (m Metrics) HarvestMetrics(
 
 ) {
 
+	if w, found := m.counters.harvest(counterID(entity, metric.Name)); found {
+		metric.SetAttribute("total.reported", w.total)
+		// Emitters that only send deltas report a zero total.
+		increase := float64(w.increase)
+		if w.total == 0 && w.increase == 0 {
+			increase = metric.Sum
+		}
+		if w.elapsed > 0 {
+			metric.SetAttribute("metric.rate", increase/w.elapsed.Seconds())
+		}
+		metric.SetAttribute("counter.reset", w.resets > 0)
+		metric.SetAttribute("counter.gap", w.gap)
+	}
+
 	metric.SetAttribute("eventType",
 		m.Config().GetString(config.NewRelicEventTypeCounterEvent),
 	)
@@ -65,6 +103,24 @@ func (m Metrics) HarvestMetrics(
 		AppendAll(entity.Attributes())
 
 	// Get a client with the insert key and RPM account ID from the config.
-	client := nrclients.New().GetEventClient(app.Get().Config.GetNewRelicConfig())
+	client := nrclients.New().GetEventClient(m.Config().GetNewRelicConfig())
 	client.EnqueueEvent(context.Background(), metric.Marshal())
 }
+
+// series identifies the emitter of a counter envelope, emitters sharing an entity are
+// told apart by their source, instance and tags.
+func series(e *loggregator_v2.Envelope) string {
+	tags := make([]string, 0, len(e.GetTags()))
+	for k, v := range e.GetTags() {
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+	return fmt.Sprintf("%s/%s/%s", e.GetSourceId(), e.GetInstanceId(), strings.Join(tags, ","))
+}
+
+// counterID of a counter of the entity.
+func counterID(entity *entities.Entity, name string) uid.ID {
+	id := entity.Signature()
+	id.Concat(name)
+	return id
+}
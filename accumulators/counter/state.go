@@ -0,0 +1,138 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package counter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/uid"
+)
+
+// counters tracks the reported totals of every counter across harvests. A counter
+// aggregates the series of every emitter sharing its entity and name, each series
+// reports its own total.
+type counters struct {
+	lock   *sync.Mutex
+	gap    time.Duration
+	state  map[uid.ID]map[string]*counterState
+	pruned time.Time
+}
+
+// counterState of a counter. The window starts at the last total of the previous harvest,
+// or the first total seen when there is no previous one. elapsed holds the time counted
+// before a gap, as the base point moves past it.
+type counterState struct {
+	baseTime  int64
+	elapsed   int64
+	total     uint64
+	timestamp int64
+	increase  uint64
+	resets    int
+	gap       bool
+	seen      time.Time
+}
+
+// window of a counter at harvest, total is the last total reported by any of its series.
+type window struct {
+	total    uint64
+	increase uint64
+	elapsed  time.Duration
+	resets   int
+	gap      bool
+}
+
+func newCounters(gap time.Duration) *counters {
+	return &counters{
+		lock:   &sync.Mutex{},
+		gap:    gap,
+		state:  map[uid.ID]map[string]*counterState{},
+		pruned: time.Now(),
+	}
+}
+
+// observe the total of a series of the counter reported at timestamp (unix nanoseconds).
+func (c *counters) observe(id uid.ID, series string, total uint64, timestamp int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.state[id] == nil {
+		c.state[id] = map[string]*counterState{}
+	}
+	s, found := c.state[id][series]
+	if !found {
+		c.state[id][series] = &counterState{baseTime: timestamp, total: total, timestamp: timestamp, seen: time.Now()}
+		return
+	}
+	if timestamp < s.timestamp {
+		// Out of order, the total is older than the one already counted.
+		return
+	}
+	switch {
+	case c.gap > 0 && timestamp-s.timestamp > c.gap.Nanoseconds():
+		// The source was gone, the increase over the gap is unknown so it is not counted.
+		// The increase before the gap is kept and the base point moves past it.
+		s.gap = true
+		s.elapsed += s.timestamp - s.baseTime
+		s.baseTime = timestamp
+	case total < s.total:
+		// The total went backwards, the counter restarted from zero, e.g. after a VM restart.
+		s.resets++
+		s.increase += total
+	default:
+		s.increase += total - s.total
+	}
+	s.total, s.timestamp, s.seen = total, timestamp, time.Now()
+}
+
+// harvest returns the window of a counter, summing the increase of its series, and
+// starts the next one at their last totals.
+func (c *counters) harvest(id uid.ID) (w window, found bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.prune()
+	series, found := c.state[id]
+	if !found {
+		return w, false
+	}
+	var last int64
+	for _, s := range series {
+		if s.timestamp >= last {
+			w.total, last = s.total, s.timestamp
+		}
+		w.increase += s.increase
+		if elapsed := time.Duration(s.elapsed + s.timestamp - s.baseTime); elapsed > w.elapsed {
+			w.elapsed = elapsed
+		}
+		w.resets += s.resets
+		w.gap = w.gap || s.gap
+		s.baseTime = s.timestamp
+		s.elapsed, s.increase, s.resets, s.gap = 0, 0, 0, false
+	}
+	return w, true
+}
+
+// prune counters of sources that have been gone for long, the state is kept for ten gaps
+// so a source coming back is flagged.
+func (c *counters) prune() {
+	ttl := 10 * c.gap
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	if time.Since(c.pruned) < ttl/10 {
+		return
+	}
+	c.pruned = time.Now()
+	for id, series := range c.state {
+		for key, s := range series {
+			if time.Since(s.seen) > ttl {
+				delete(series, key)
+			}
+		}
+		if len(series) == 0 {
+			delete(c.state, id)
+		}
+	}
+}
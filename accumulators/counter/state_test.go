@@ -0,0 +1,91 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package counter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const second = int64(time.Second)
+
+func TestCounterRate(t *testing.T) {
+	c := newCounters(time.Minute)
+	c.observe("a", "s", 100, 0)
+	c.observe("a", "s", 150, 5*second)
+	c.observe("a", "s", 200, 10*second)
+
+	w, found := c.harvest("a")
+	assert.True(t, found)
+	assert.Equal(t, uint64(100), w.increase)
+	assert.Equal(t, 10*time.Second, w.elapsed)
+	assert.Equal(t, 0, w.resets)
+
+	// The next window starts at the last total of the previous one.
+	c.observe("a", "s", 260, 20*second)
+	w, _ = c.harvest("a")
+	assert.Equal(t, uint64(60), w.increase)
+	assert.Equal(t, 10*time.Second, w.elapsed)
+	assert.Equal(t, uint64(260), w.total)
+}
+
+func TestCounterReset(t *testing.T) {
+	c := newCounters(time.Minute)
+	c.observe("a", "s", 1000, 0)
+	c.observe("a", "s", 30, 10*second)
+	c.observe("a", "s", 50, 20*second)
+
+	w, _ := c.harvest("a")
+	assert.Equal(t, 1, w.resets)
+	assert.Equal(t, uint64(50), w.increase)
+
+	c.observe("a", "s", 60, 30*second)
+	w, _ = c.harvest("a")
+	assert.Equal(t, 0, w.resets)
+}
+
+func TestCounterGap(t *testing.T) {
+	c := newCounters(time.Minute)
+	c.observe("a", "s", 100, 0)
+	c.harvest("a")
+
+	c.observe("a", "s", 900, 10*60*second)
+	c.observe("a", "s", 1000, 10*60*second+10*second)
+	w, _ := c.harvest("a")
+	assert.True(t, w.gap)
+	assert.Equal(t, uint64(100), w.increase)
+	assert.Equal(t, 10*time.Second, w.elapsed)
+
+	// The increase before a gap is kept, the gap itself is not counted.
+	c.observe("a", "s", 1050, 10*60*second+20*second)
+	c.observe("a", "s", 5000, 30*60*second)
+	c.observe("a", "s", 5030, 30*60*second+10*second)
+	w, _ = c.harvest("a")
+	assert.True(t, w.gap)
+	assert.Equal(t, uint64(80), w.increase)
+	assert.Equal(t, 20*time.Second, w.elapsed)
+
+	_, found := c.harvest("b")
+	assert.False(t, found)
+}
+
+func TestCounterSeries(t *testing.T) {
+	c := newCounters(time.Minute)
+	c.observe("a", "x", 1000, 0)
+	c.observe("a", "y", 10, 0)
+	c.observe("a", "x", 1100, 10*second)
+	c.observe("a", "y", 30, 10*second)
+
+	w, _ := c.harvest("a")
+	assert.Equal(t, 0, w.resets)
+	assert.Equal(t, uint64(120), w.increase)
+
+	// The total is the last one reported, as emitted by the most recent series.
+	c.observe("a", "y", 40, 20*second)
+	c.observe("a", "x", 1200, 15*second)
+	w, _ = c.harvest("a")
+	assert.Equal(t, uint64(40), w.total)
+}
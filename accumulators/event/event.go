@@ -0,0 +1,68 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package event
+
+import (
+	"context"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/accumulators"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/entities"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/metrics"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrclients"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrpcf"
+)
+
+// Nrevents extends event.Accumulator for
+// Firehose Event Envelope Event Types
+type Nrevents struct {
+	accumulators.Accumulator
+}
+
+// New satisfies event.Accumulator
+func (n Nrevents) New(c *config.Config) accumulators.Interface {
+	i := Nrevents{
+		Accumulator: accumulators.NewAccumulator(
+			c,
+			"*loggregator_v2.Envelope_Event",
+		),
+	}
+	return i
+}
+
+// Update satisfies event.Accumulator
+func (n Nrevents) Update(e *loggregator_v2.Envelope) {
+	entity := n.GetEventEntity(e, nrpcf.GetPCFAttributes(e))
+	s := attributes.NewAttributes()
+	s.SetAttribute("timestamp", (e.GetTimestamp() / (int64(time.Millisecond) / int64(time.Nanosecond))))
+	s.SetAttribute("event.title", e.GetEvent().GetTitle())
+	s.SetAttribute("event.body", e.GetEvent().GetBody())
+	s.SetAttribute("event.source.id", e.GetSourceId())
+	s.SetAttribute("event.source.instance", e.GetInstanceId())
+	// Platform components describe the event in the envelope tags.
+	for name, val := range e.GetTags() {
+		s.SetAttribute("tags."+name, val)
+	}
+	s.SetAttribute("agent.subscription", n.Config().GetString("FIREHOSE_ID"))
+	s.SetAttribute(
+		"eventType",
+		n.Config().GetString(config.NewRelicEventTypeEvent),
+	)
+
+	s.AppendAll(entity.Attributes())
+
+	// Get an insert client and enqueue the event.
+	client := nrclients.New().GetEventClient(n.Config().GetNewRelicConfig())
+	client.EnqueueEvent(context.Background(), s.Marshal())
+}
+
+// HarvestMetrics - stub for Events, which are all events...
+func (n Nrevents) HarvestMetrics(
+	entity *entities.Entity,
+	metric *metrics.Metric,
+) {
+}
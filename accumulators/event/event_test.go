@@ -0,0 +1,53 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrclients"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdate(t *testing.T) {
+	var sink bytes.Buffer
+	nrclients.New().SetSink(nrclients.NewWriterClient(&sink))
+	defer nrclients.New().SetSink(nil)
+
+	n := Nrevents{}.New(config.Get().Scoped("event")).(Nrevents)
+	n.Update(&loggregator_v2.Envelope{
+		Timestamp:  1600000000123456789,
+		SourceId:   "bosh-system-metrics-forwarder",
+		InstanceId: "6f5a0d1c",
+		Tags:       map[string]string{"deployment": "cf", "job": "diego-cell", "severity": "4"},
+		Message: &loggregator_v2.Envelope_Event{Event: &loggregator_v2.Event{
+			Title: "VM unresponsive",
+			Body:  "diego-cell/0 has been unresponsive for 5 minutes",
+		}},
+	})
+	nrclients.New().FlushAll()
+
+	var events []map[string]interface{}
+	for d := json.NewDecoder(&sink); d.More(); {
+		var event map[string]interface{}
+		assert.NoError(t, d.Decode(&event))
+		events = append(events, event)
+	}
+	if assert.Len(t, events, 1) {
+		e := events[0]
+		assert.Equal(t, "PCFEvent", e["eventType"])
+		assert.Equal(t, float64(1600000000123), e["timestamp"])
+		assert.Equal(t, "VM unresponsive", e["event.title"])
+		assert.Equal(t, "diego-cell/0 has been unresponsive for 5 minutes", e["event.body"])
+		assert.Equal(t, "bosh-system-metrics-forwarder", e["event.source.id"])
+		assert.Equal(t, "6f5a0d1c", e["event.source.instance"])
+		assert.Equal(t, "4", e["tags.severity"])
+		assert.Equal(t, "diego-cell", e["tags.job"])
+		assert.Equal(t, "cf", e[config.Get().AttributeName(config.EnvDeployment)])
+	}
+}
@@ -5,6 +5,7 @@ package http
 
 import (
 	"context"
+	"fmt"
 	"strconv"
 	"time"
 
@@ -24,23 +25,40 @@ import (
 type Nrevents struct {
 	accumulators.Accumulator
 	logsEnabled bool
+	aggregate   bool
+	redactor    *accumulators.Redactor
 }
 
 // New satisfies event.Accumulator
-func (n Nrevents) New() accumulators.Interface {
+func (n Nrevents) New(c *config.Config) accumulators.Interface {
 	i := Nrevents{
 		Accumulator: accumulators.NewAccumulator(
+			c,
 			"*loggregator_v2.Envelope_Timer",
 		),
 	}
 	i.logsEnabled = i.Config().GetBool("LOGS_HTTP")
+	i.aggregate = i.Config().GetBool("HTTP_AGGREGATE")
+	redactor, err := accumulators.NewRedactor(
+		i.Config().GetString("REDACT_DETECTORS"),
+		i.Config().GetString("REDACT_RULES"),
+		i.Config().GetString("REDACT_HASH_KEY"),
+	)
+	if err != nil {
+		app.Get().Log.Fatalf("invalid redaction settings: %s", err.Error())
+	}
+	i.redactor = redactor
 	return i
 }
 
 // Update satisfies event.Accumulator
 // func (n Nrevents) Update(e *events.Envelope) {
 func (n Nrevents) Update(e *loggregator_v2.Envelope) {
-	entity := n.GetEntity(e, nrpcf.GetPCFAttributes(e))
+	if n.aggregate {
+		n.accumulate(e)
+		return
+	}
+	entity := n.GetEventEntity(e, nrpcf.GetPCFAttributes(e))
 	s := attributes.NewAttributes()
 	s.SetAttribute("timestamp", (e.GetTimestamp() / (int64(time.Millisecond) / int64(time.Nanosecond))))
 	s.SetAttribute("http.duration", float64(n.GetDuration(e)))
@@ -52,13 +70,13 @@ func (n Nrevents) Update(e *loggregator_v2.Envelope) {
 	if scErr == nil {
 		s.SetAttribute("http.status", sc)
 	}
-	s.SetAttribute("http.uri", n.GetTag(e, "uri"))
+	n.setRedacted(s, e, "http.uri", n.GetTag(e, "uri"))
 	s.SetAttribute("http.method", n.GetTag(e, "method"))
 	s.SetAttribute("http.peer.type", n.GetTag(e, "peer_type"))
 	s.SetAttribute("http.start.timestamp", e.GetTimer().GetStart())
 	s.SetAttribute("http.stop.timestamp", e.GetTimer().GetStop())
 	s.SetAttribute("http.remote.address", n.GetTag(e, "remote_address"))
-	s.SetAttribute("http.user.agent", n.GetTag(e, "user_agent"))
+	n.setRedacted(s, e, "http.user.agent", n.GetTag(e, "user_agent"))
 	s.SetAttribute("http.request.id", n.GetTag(e, "request_id"))
 
 	s.SetAttribute("agent.subscription", n.Config().GetString("FIREHOSE_ID"))
@@ -66,7 +84,7 @@ func (n Nrevents) Update(e *loggregator_v2.Envelope) {
 	s.AppendAll(entity.Attributes())
 
 	if n.logsEnabled {
-		client := nrclients.New().GetLogClient(app.Get().Config.GetNewRelicConfig())
+		client := nrclients.New().GetLogClient(n.Config().GetNewRelicConfig())
 		client.EnqueueLogEntry(context.Background(), s.Marshal())
 		return
 	}
@@ -75,15 +93,79 @@ func (n Nrevents) Update(e *loggregator_v2.Envelope) {
 		n.Config().GetString(config.NewRelicEventTypeHTTPStartStop),
 	)
 	// Get an insert client and enqueue the event.
-	client := nrclients.New().GetEventClient(app.Get().Config.GetNewRelicConfig())
+	client := nrclients.New().GetEventClient(n.Config().GetNewRelicConfig())
 	client.EnqueueEvent(context.Background(), s.Marshal())
 }
 
-// HarvestMetrics (stub for HttpStartStop)...
+// setRedacted sets the attribute to the redacted value, unless a redaction rule drops it.
+func (n Nrevents) setRedacted(s *attributes.Attributes, e *loggregator_v2.Envelope, name string, value string) {
+	if value, drop := n.redactor.Redact(value, n.CountRedactions(e, name)); !drop {
+		s.SetAttribute(name, value)
+	}
+}
+
+// accumulate the timer into the latency metric of its app, method, status class and peer type.
+func (n Nrevents) accumulate(e *loggregator_v2.Envelope) {
+	class := statusClass(n.GetTag(e, "status_code"))
+	attrs := attributes.NewAttributes(
+		attributes.New(n.Config().AttributeName(config.EnvAppID), e.GetSourceId()),
+		attributes.New("http.method", n.GetTag(e, "method")),
+		attributes.New("http.status.class", class),
+		attributes.New("http.peer.type", n.GetTag(e, "peer_type")),
+	)
+	n.GetEntity(e, attrs).NewSample(
+		"http.duration",
+		metrics.Types.Distribution,
+		"ms",
+		n.GetDuration(e),
+	).Done()
+}
+
+// HarvestMetrics sends the aggregated latency of each app, method, status class and peer type,
+// and the redaction hits, HttpStartStop events are otherwise sent by Update.
 func (n Nrevents) HarvestMetrics(
 	entity *entities.Entity,
 	metric *metrics.Metric,
 ) {
+	if metric.Name == accumulators.RedactionHits {
+		metric.SetAttribute("redaction.hit.count", int(metric.Sum))
+		metric.SetAttribute("eventType", n.Config().GetString(config.NewRelicEventTypeRedaction))
+	} else {
+		// The status class is part of the aggregate, every request of a 5xx aggregate is an error.
+		errors := 0
+		if class := entity.AttributeByName("http.status.class"); class != nil && class.Value() == "5xx" {
+			errors = metric.Samples
+		}
+		metric.SetAttribute("http.count", metric.Samples)
+		metric.SetAttribute("http.error.count", errors)
+		metric.SetPercentiles("http.duration", n.Percentiles())
+		metric.SetAttribute("eventType", n.Config().GetString(config.NewRelicEventTypeHTTPLatency))
+	}
+	metric.SetAttribute("agent.subscription", n.Config().GetString("FIREHOSE_ID"))
+	metric.SetAttribute(n.Config().AttributeName(config.EnvDomain), nrpcf.PCFDomain())
+
+	metric.Attributes().
+		AppendAll(entity.Attributes())
+
+	// Only apps have app attributes, looking up platform components such as gorouter would
+	// fetch them from the CF API in vain.
+	if id := entity.AttributeByName(n.Config().AttributeName(config.EnvAppID)); id != nil {
+		if guid, _ := id.Value().(string); nrpcf.IsAppGUID(guid) {
+			metric.Attributes().AppendAll(nrpcf.GetAppAttributes(guid))
+		}
+	}
+
+	client := nrpcf.GetInsertClientForApp(entity, n.Config())
+	client.EnqueueEvent(context.Background(), metric.Marshal())
+}
+
+// statusClass of a status code, i.e. 2xx.
+func statusClass(code string) string {
+	sc, err := strconv.Atoi(code)
+	if err != nil || sc < 100 || sc > 599 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%dxx", sc/100)
 }
 
 // GetDuration ...
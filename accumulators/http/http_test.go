@@ -0,0 +1,112 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/cfapps"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrclients"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, "2xx", statusClass("204"))
+	assert.Equal(t, "5xx", statusClass("503"))
+	assert.Equal(t, "unknown", statusClass(""))
+}
+
+func timer(ms int, status string) *loggregator_v2.Envelope {
+	return &loggregator_v2.Envelope{
+		SourceId: "6f5a0d1c-2c0e-4f9f-9b55-1f5e0a6b8b11",
+		Tags:     map[string]string{"method": "GET", "status_code": status, "peer_type": "Server"},
+		Message: &loggregator_v2.Envelope_Timer{Timer: &loggregator_v2.Timer{
+			Start: 0,
+			Stop:  int64(ms) * int64(time.Millisecond),
+		}},
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	os.Setenv("NRF_ACC_LATENCY_HTTP_AGGREGATE", "true")
+	os.Setenv("NRF_ACC_LATENCY_METRICS_PERCENTILES", "50|99")
+	defer os.Unsetenv("NRF_ACC_LATENCY_HTTP_AGGREGATE")
+	defer os.Unsetenv("NRF_ACC_LATENCY_METRICS_PERCENTILES")
+	cfapps.StartOffline(app.Get())
+	var sink bytes.Buffer
+	nrclients.New().SetSink(nrclients.NewWriterClient(&sink))
+	defer nrclients.New().SetSink(nil)
+
+	n := Nrevents{}.New(config.Get().Scoped("latency")).(Nrevents)
+	for ms := 100; ms > 0; ms-- {
+		n.Update(timer(ms, "200"))
+	}
+	n.Update(timer(500, "503"))
+	for _, entity := range n.Drain() {
+		for _, metric := range entity.DrainMetrics() {
+			n.HarvestMetrics(entity, metric)
+		}
+	}
+	nrclients.New().FlushAll()
+
+	events := map[string]map[string]interface{}{}
+	for d := json.NewDecoder(&sink); d.More(); {
+		var event map[string]interface{}
+		assert.NoError(t, d.Decode(&event))
+		events[event["http.status.class"].(string)] = event
+	}
+	ok, failed := events["2xx"], events["5xx"]
+	if assert.NotNil(t, ok) && assert.NotNil(t, failed) {
+		assert.Equal(t, "PCFHttpLatency", ok["eventType"])
+		assert.Equal(t, float64(100), ok["http.count"])
+		assert.Equal(t, float64(0), ok["http.error.count"])
+		assert.InEpsilon(t, 50, ok["http.duration.p50"], 0.02)
+		assert.InEpsilon(t, 99, ok["http.duration.p99"], 0.02)
+		assert.NotContains(t, ok, "http.duration.p90")
+		assert.NotContains(t, ok, "metric.p50")
+
+		assert.Equal(t, float64(1), failed["http.error.count"])
+		assert.InEpsilon(t, 500, failed["http.duration.p50"], 0.02)
+	}
+}
+
+func TestLatencyAppAttributes(t *testing.T) {
+	os.Setenv("NRF_ACC_PLATFORM_HTTP_AGGREGATE", "true")
+	defer os.Unsetenv("NRF_ACC_PLATFORM_HTTP_AGGREGATE")
+	cfapps.StartOffline(app.Get())
+	var sink bytes.Buffer
+	nrclients.New().SetSink(nrclients.NewWriterClient(&sink))
+	defer nrclients.New().SetSink(nil)
+
+	n := Nrevents{}.New(config.Get().Scoped("platform")).(Nrevents)
+	n.Update(timer(10, "200"))
+	platform := timer(10, "200")
+	platform.SourceId = "gorouter"
+	n.Update(platform)
+	for _, entity := range n.Drain() {
+		for _, metric := range entity.DrainMetrics() {
+			n.HarvestMetrics(entity, metric)
+		}
+	}
+	nrclients.New().FlushAll()
+
+	events := map[string]map[string]interface{}{}
+	for d := json.NewDecoder(&sink); d.More(); {
+		var event map[string]interface{}
+		assert.NoError(t, d.Decode(&event))
+		events[event[config.Get().AttributeName(config.EnvAppID)].(string)] = event
+	}
+	// Platform components are not looked up as apps.
+	assert.Contains(t, events[timer(0, "").SourceId], cfapps.AppName)
+	if assert.Contains(t, events, "gorouter") {
+		assert.NotContains(t, events["gorouter"], cfapps.AppName)
+	}
+}
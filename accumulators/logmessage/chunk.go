@@ -0,0 +1,62 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"unicode/utf8"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrclients"
+)
+
+// maxEventMessage is the longest log message the Event API accepts.
+const maxEventMessage = 4096
+
+// LOGMESSAGE_OVERSIZE handling of log messages longer than maxEventMessage sent as events.
+const (
+	oversizeTruncate = "truncate"
+	oversizeChunk    = "chunk"
+	oversizeLogs     = "logs"
+)
+
+func validOversize(oversize string) bool {
+	return oversize == oversizeTruncate || oversize == oversizeChunk || oversize == oversizeLogs
+}
+
+// sendChunks sends the message as ordered events holding up to maxEventMessage bytes of it,
+// sharing the attributes of event and a log.message.id.
+func sendChunks(client nrclients.EventClient, event map[string]interface{}, message []byte) {
+	parts := chunks(message, maxEventMessage)
+	id := fmt.Sprintf("%016x", rand.Uint64())
+	for i, part := range parts {
+		chunk := make(map[string]interface{}, len(event)+4)
+		for k, v := range event {
+			chunk[k] = v
+		}
+		chunk["log.message"] = string(part)
+		chunk["log.message.id"] = id
+		chunk["log.message.part"] = i + 1
+		chunk["log.message.parts"] = len(parts)
+		client.EnqueueEvent(context.Background(), chunk)
+	}
+}
+
+// chunks of the message of up to size bytes, not splitting UTF-8 characters.
+func chunks(message []byte, size int) [][]byte {
+	var parts [][]byte
+	for len(message) > size {
+		end := size
+		for end > 0 && !utf8.RuneStart(message[end]) {
+			end--
+		}
+		if end == 0 {
+			end = size
+		}
+		parts = append(parts, message[:end])
+		message = message[end:]
+	}
+	return append(parts, message)
+}
@@ -0,0 +1,27 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunks(t *testing.T) {
+	assert.Equal(t, [][]byte{[]byte("short")}, chunks([]byte("short"), 8))
+	assert.Equal(t, [][]byte{[]byte("12345678"), []byte("12345678"), []byte("9")}, chunks([]byte("12345678123456789"), 8))
+
+	// UTF-8 characters are not split.
+	message := []byte(strings.Repeat("aé", 5))
+	parts := chunks(message, 4)
+	assert.Equal(t, message, bytes.Join(parts, nil))
+	for _, p := range parts {
+		assert.True(t, len(p) <= 4)
+		assert.True(t, utf8.Valid(p))
+	}
+}
@@ -0,0 +1,389 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+)
+
+// Filter is a compiled LOGMESSAGE_FILTER expression. An expression compares fields of the
+// log message and combines the comparisons with AND, OR, NOT and parentheses, e.g.
+//
+//	source_type == "APP/PROC/WEB" AND NOT message =~ /health ?check/ AND (org ^= "prod-" OR app == "billing")
+//
+// Fields are app, org, space (names), app_id, source_type, message_type (OUT or ERR) and
+// message. Operators are == and != (exact), ^= (starts with) and =~ and !~ (regular
+// expression, between slashes or quoted).
+type Filter struct {
+	root expr
+}
+
+// fields of a log message, the app is only looked up when the filter needs it.
+type fields struct {
+	values map[string]string
+	app    func() (name, org, space string)
+	looked bool
+}
+
+func (f *fields) get(name string) string {
+	switch name {
+	case "app", "org", "space":
+		if !f.looked {
+			f.looked = true
+			f.values["app"], f.values["org"], f.values["space"] = f.app()
+		}
+	}
+	return f.values[name]
+}
+
+// filterFields a filter can compare.
+var filterFields = map[string]bool{
+	"app": true, "org": true, "space": true, "app_id": true,
+	"source_type": true, "message_type": true, "message": true,
+}
+
+type expr interface {
+	eval(f *fields) bool
+}
+
+type and struct{ left, right expr }
+type or struct{ left, right expr }
+type not struct{ expr expr }
+
+type comparison struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+func (e and) eval(f *fields) bool { return e.left.eval(f) && e.right.eval(f) }
+func (e or) eval(f *fields) bool  { return e.left.eval(f) || e.right.eval(f) }
+func (e not) eval(f *fields) bool { return !e.expr.eval(f) }
+
+func (c comparison) eval(f *fields) bool {
+	v := f.get(c.field)
+	switch c.op {
+	case "==":
+		return v == c.value
+	case "!=":
+		return v != c.value
+	case "^=":
+		return strings.HasPrefix(v, c.value)
+	case "contains":
+		return strings.Contains(v, c.value)
+	case "=~":
+		return c.re.MatchString(v)
+	}
+	return !c.re.MatchString(v)
+}
+
+// NewFilter compiles LOGMESSAGE_FILTER and the LOGMESSAGE_SOURCE_* and LOGMESSAGE_MESSAGE_*
+// lists, a log message must pass both. It returns nil when no filter is set.
+func NewFilter(c *config.Config) (*Filter, error) {
+	var root expr
+	if s := strings.TrimSpace(c.GetString("LOGMESSAGE_FILTER")); s != "" {
+		var err error
+		if root, err = parseFilter(s); err != nil {
+			return nil, fmt.Errorf("NRF_LOGMESSAGE_FILTER: %s", err.Error())
+		}
+	}
+	if legacy := legacyFilter(
+		c.GetFilter("LOGMESSAGE_SOURCE_INCLUDE"),
+		c.GetFilter("LOGMESSAGE_SOURCE_EXCLUDE"),
+		c.GetFilter("LOGMESSAGE_MESSAGE_INCLUDE"),
+		c.GetFilter("LOGMESSAGE_MESSAGE_EXCLUDE"),
+	); legacy != nil {
+		root = both(root, legacy)
+	}
+	if root == nil {
+		return nil, nil
+	}
+	return &Filter{root: root}, nil
+}
+
+// Match reports whether the log message passes the filter.
+func (f *Filter) Match(fl *fields) bool {
+	return f.root.eval(fl)
+}
+
+// legacyFilter is the expression of the source and message lists: the source must be one
+// of the included sources and the message contain one of the included patterns, when set,
+// and neither be excluded.
+func legacyFilter(sourceInc, sourceExc, messageInc, messageExc []string) expr {
+	var root expr
+	if e := anyOf("source_type", "==", sourceInc); e != nil {
+		root = both(root, e)
+	}
+	if e := anyOf("message", "contains", messageInc); e != nil {
+		root = both(root, e)
+	}
+	if e := anyOf("source_type", "==", sourceExc); e != nil {
+		root = both(root, not{e})
+	}
+	if e := anyOf("message", "contains", messageExc); e != nil {
+		root = both(root, not{e})
+	}
+	return root
+}
+
+func anyOf(field string, op string, values []string) expr {
+	var e expr
+	for _, v := range values {
+		c := comparison{field: field, op: op, value: strings.TrimSpace(v)}
+		if e == nil {
+			e = c
+		} else {
+			e = or{e, c}
+		}
+	}
+	return e
+}
+
+func both(left, right expr) expr {
+	if left == nil {
+		return right
+	}
+	return and{left, right}
+}
+
+// parseFilter compiles an expression:
+//
+//	or         = and { OR and }
+//	and        = not { AND not }
+//	not        = NOT not | "(" or ")" | comparison
+//	comparison = field operator ( string | regexp )
+func parseFilter(s string) (expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return e, nil
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokenEnd {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) or() (expr, error) {
+	left, err := p.and()
+	for err == nil && p.peek().kind == tokenOr {
+		p.next()
+		var right expr
+		if right, err = p.and(); err == nil {
+			left = or{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) and() (expr, error) {
+	left, err := p.not()
+	for err == nil && p.peek().kind == tokenAnd {
+		p.next()
+		var right expr
+		if right, err = p.not(); err == nil {
+			left = and{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) not() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNot:
+		e, err := p.not()
+		return not{e}, err
+	case tokenOpen:
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.next(); c.kind != tokenClose {
+			return nil, fmt.Errorf("expected ) at %d", c.pos)
+		}
+		return e, nil
+	case tokenWord:
+		return p.comparison(t)
+	}
+	return nil, fmt.Errorf("expected a field at %d", t.pos)
+}
+
+func (p *parser) comparison(field token) (expr, error) {
+	if !filterFields[field.text] {
+		return nil, fmt.Errorf("unknown field %q at %d", field.text, field.pos)
+	}
+	op := p.next()
+	if op.kind != tokenOperator {
+		return nil, fmt.Errorf("expected an operator after %s at %d", field.text, op.pos)
+	}
+	value := p.next()
+	if value.kind != tokenString && value.kind != tokenRegexp {
+		return nil, fmt.Errorf("expected a value after %s at %d", op.text, value.pos)
+	}
+	c := comparison{field: field.text, op: op.text, value: value.text}
+	switch op.text {
+	case "=~", "!~":
+		re, err := regexp.Compile(value.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression at %d: %s", value.pos, err.Error())
+		}
+		c.re = re
+	default:
+		if value.kind == tokenRegexp {
+			return nil, fmt.Errorf("%s compares a string, not a regular expression, at %d", op.text, value.pos)
+		}
+	}
+	return c, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenWord
+	tokenString
+	tokenRegexp
+	tokenOperator
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var operators = []string{"==", "!=", "^=", "=~", "!~", "&&", "||"}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c == '(':
+			tokens = append(tokens, token{tokenOpen, "(", i})
+			i++
+			continue
+		case c == ')':
+			tokens = append(tokens, token{tokenClose, ")", i})
+			i++
+			continue
+		case c == '"':
+			end := closing(s, i, '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			v, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d", i)
+			}
+			tokens = append(tokens, token{tokenString, v, i})
+			i = end + 1
+			continue
+		case c == '/':
+			end := closing(s, i, '/')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated regular expression at %d", i)
+			}
+			tokens = append(tokens, token{tokenRegexp, strings.ReplaceAll(s[i+1:end], `\/`, "/"), i})
+			i = end + 1
+			continue
+		}
+		if op := operator(s[i:]); op != "" {
+			kind := tokenOperator
+			switch op {
+			case "&&":
+				kind = tokenAnd
+			case "||":
+				kind = tokenOr
+			}
+			tokens = append(tokens, token{kind, op, i})
+			i += len(op)
+			continue
+		}
+		if c == '!' {
+			tokens = append(tokens, token{tokenNot, "!", i})
+			i++
+			continue
+		}
+		start := i
+		for i < len(s) && (unicode.IsLetter(rune(s[i])) || unicode.IsDigit(rune(s[i])) || s[i] == '_') {
+			i++
+		}
+		if i == start {
+			return nil, fmt.Errorf("unexpected %q at %d", s[i], i)
+		}
+		word := s[start:i]
+		switch strings.ToUpper(word) {
+		case "AND":
+			tokens = append(tokens, token{tokenAnd, word, start})
+		case "OR":
+			tokens = append(tokens, token{tokenOr, word, start})
+		case "NOT":
+			tokens = append(tokens, token{tokenNot, word, start})
+		default:
+			tokens = append(tokens, token{tokenWord, word, start})
+		}
+	}
+	return append(tokens, token{tokenEnd, "end of filter", len(s)}), nil
+}
+
+func operator(s string) string {
+	for _, op := range operators {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// closing returns the index of the delimiter ending the literal starting at i, skipping
+// backslash escapes.
+func closing(s string, i int, delim byte) int {
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case delim:
+			return j
+		}
+	}
+	return -1
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testFields(source, message string) *fields {
+	return &fields{
+		values: map[string]string{
+			"app_id":       "guid",
+			"source_type":  source,
+			"message_type": "OUT",
+			"message":      message,
+		},
+		app: func() (string, string, string) { return "billing", "prod-eu", "web" },
+	}
+}
+
+func TestFilterExpression(t *testing.T) {
+	e, err := parseFilter(`source_type == "APP/PROC/WEB" AND NOT message =~ /health ?check/ AND (org ^= "prod-" OR app == "other")`)
+	assert.NoError(t, err)
+	assert.True(t, e.eval(testFields("APP/PROC/WEB", "GET /orders 200")))
+	assert.False(t, e.eval(testFields("APP/PROC/WEB", "GET /healthcheck 200")))
+	assert.False(t, e.eval(testFields("RTR", "GET /orders 200")))
+
+	e, err = parseFilter(`!(message_type != "OUT") && message !~ "^DEBUG" || space == "web"`)
+	assert.NoError(t, err)
+	assert.True(t, e.eval(testFields("RTR", "DEBUG ignored")))
+}
+
+func TestFilterAppLookedUpOnce(t *testing.T) {
+	lookups := 0
+	f := testFields("RTR", "")
+	f.app = func() (string, string, string) {
+		lookups++
+		return "billing", "org", "space"
+	}
+	e, err := parseFilter(`app == "billing" AND org == "org" AND space == "space"`)
+	assert.NoError(t, err)
+	assert.True(t, e.eval(f))
+	assert.Equal(t, 1, lookups)
+
+	e, _ = parseFilter(`source_type == "RTR"`)
+	assert.True(t, e.eval(testFields("RTR", "")))
+}
+
+func TestFilterErrors(t *testing.T) {
+	for _, s := range []string{
+		`host == "a"`,
+		`message == /a/`,
+		`message =~ /[/`,
+		`message ==`,
+		`(message == "a"`,
+		`message == "a" message == "b"`,
+		`message == "a`,
+	} {
+		_, err := parseFilter(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestLegacyFilter(t *testing.T) {
+	e := legacyFilter([]string{"APP/PROC/WEB", "RTR"}, nil, []string{"ERROR"}, []string{"DEBUG"})
+	assert.True(t, e.eval(testFields("RTR", "ERROR failed")))
+	assert.False(t, e.eval(testFields("STG", "ERROR failed")))
+	assert.False(t, e.eval(testFields("RTR", "ERROR DEBUG")))
+	assert.False(t, e.eval(testFields("RTR", "INFO")))
+
+	assert.Nil(t, legacyFilter(nil, nil, nil, nil))
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
+)
+
+// accessLog matches the fixed fields of Gorouter access log lines:
+//
+//	host - [time] "method path protocol" status bytes_received bytes_sent "referer" "user_agent" "remote" "backend" key:value...
+var accessLog = regexp.MustCompile(
+	`^(\S+) - \[[^\]]*\] "(\S+) (\S+) ([^"]*)" (\S+) (\S+) (\S+) "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)" "([^"]*)" "([^"]*)"(.*)$`,
+)
+
+// accessLogField matches the key:value and key:"value" fields following the fixed ones.
+var accessLogField = regexp.MustCompile(`(\w+):("(?:[^"\\]|\\.)*"|\S+)`)
+
+// gorouterFields are the attributes of the key:value fields, other keys, such as extra
+// headers to log, are kept as rtr.<key>. The b3 trace ids are handled by parse.
+var gorouterFields = map[string]string{
+	"x_forwarded_for":   "rtr.x_forwarded_for",
+	"x_forwarded_proto": "rtr.x_forwarded_proto",
+	"vcap_request_id":   "rtr.vcap_request_id",
+	"response_time":     "rtr.response_time",
+	"gorouter_time":     "rtr.gorouter_time",
+	"app_id":            "rtr.app_id",
+	"app_index":         "rtr.app_index",
+	"instance_id":       "rtr.instance_id",
+	"x_cf_routererror":  "rtr.routererror",
+}
+
+// gorouterParser extracts Gorouter (RTR) access log fields into rtr.* attributes, the
+// status, bytes and app_index as integers and the times, in seconds, as floats.
+type gorouterParser struct {
+	parsedOnly bool
+}
+
+// newGorouterParser from LOGMESSAGE_GOROUTER*, nil when parsing is disabled.
+func newGorouterParser(c *config.Config) *gorouterParser {
+	if !c.GetBool("LOGMESSAGE_GOROUTER") {
+		return nil
+	}
+	return &gorouterParser{parsedOnly: c.GetBool("LOGMESSAGE_GOROUTER_PARSED_ONLY")}
+}
+
+// parse the access log line into attrs, returning false when it isn't one.
+func (p *gorouterParser) parse(payload []byte, attrs *attributes.Attributes) bool {
+	m := accessLog.FindSubmatch(payload)
+	if m == nil {
+		return false
+	}
+	setString(attrs, "rtr.host", string(m[1]))
+	setString(attrs, "rtr.method", string(m[2]))
+	setString(attrs, "rtr.path", string(m[3]))
+	setString(attrs, "rtr.protocol", string(m[4]))
+	setInt(attrs, "rtr.status", string(m[5]))
+	setInt(attrs, "rtr.bytes_received", string(m[6]))
+	setInt(attrs, "rtr.bytes_sent", string(m[7]))
+	setString(attrs, "rtr.referer", string(m[8]))
+	setString(attrs, "rtr.user_agent", string(m[9]))
+	setString(attrs, "rtr.remote_address", string(m[10]))
+	setString(attrs, "rtr.backend_address", string(m[11]))
+
+	fields := map[string]string{}
+	for _, f := range accessLogField.FindAllSubmatch(m[12], -1) {
+		fields[string(f[1])] = unquote(string(f[2]))
+	}
+	for key, value := range fields {
+		switch key {
+		case "response_time", "gorouter_time":
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				attrs.SetAttribute(gorouterFields[key], v)
+			}
+		case "app_index":
+			setInt(attrs, gorouterFields[key], value)
+		case "x_b3_traceid", "x_b3_spanid", "x_b3_parentspanid", "b3":
+		default:
+			name, found := gorouterFields[key]
+			if !found {
+				name = "rtr." + key
+			}
+			setString(attrs, name, value)
+		}
+	}
+
+	// The b3 header is traceid-spanid[-sampled[-parentspanid]], the x_b3_* headers win.
+	b3 := strings.Split(fields["b3"], "-")
+	trace, span, parent := fields["x_b3_traceid"], fields["x_b3_spanid"], fields["x_b3_parentspanid"]
+	if (trace == "" || trace == "-") && len(b3) >= 2 {
+		trace, span = b3[0], b3[1]
+		if len(b3) == 4 {
+			parent = b3[3]
+		}
+	}
+	setString(attrs, "trace.id", trace)
+	setString(attrs, "span.id", span)
+	setString(attrs, "parent.id", parent)
+	return true
+}
+
+// setString sets the attribute, unless the value is missing.
+func setString(attrs *attributes.Attributes, name string, value string) {
+	if value != "" && value != "-" {
+		attrs.SetAttribute(name, value)
+	}
+}
+
+// setInt sets the attribute to the integer value, unless it isn't one.
+func setInt(attrs *attributes.Attributes, name string, value string) {
+	if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+		attrs.SetAttribute(name, v)
+	}
+}
+
+// unquote the value when it is quoted, keeping it as is when it can't be.
+func unquote(value string) string {
+	if len(value) < 2 || value[0] != '"' {
+		return value
+	}
+	if v, err := strconv.Unquote(value); err == nil {
+		return v
+	}
+	return value[1 : len(value)-1]
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"testing"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGorouterParse(t *testing.T) {
+	line := `app.example.com - [2020-02-09T22:47:04.917+0000] "GET /orders?id=1 HTTP/1.1" 200 12 345 "-" "curl/7.64.1 \"x\"" ` +
+		`"10.0.0.1:54321" "10.0.1.2:61000" x_forwarded_for:"203.0.113.9, 10.0.0.1" x_forwarded_proto:"https" ` +
+		`vcap_request_id:"a1b2c3d4-0000-4000-8000-000000000001" response_time:0.012345 gorouter_time:0.000123 ` +
+		`app_id:"app-1" app_index:"2" instance_id:"f00d" x_cf_routererror:"-" x_b3_traceid:"-" x_b3_spanid:"-" ` +
+		`x_b3_parentspanid:"-" b3:"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90" tenant:"acme"`
+
+	attrs := attributes.NewAttributes()
+	assert.True(t, (&gorouterParser{}).parse([]byte(line), attrs))
+	assert.Equal(t, map[string]interface{}{
+		"rtr.host":              "app.example.com",
+		"rtr.method":            "GET",
+		"rtr.path":              "/orders?id=1",
+		"rtr.protocol":          "HTTP/1.1",
+		"rtr.status":            int64(200),
+		"rtr.bytes_received":    int64(12),
+		"rtr.bytes_sent":        int64(345),
+		"rtr.user_agent":        `curl/7.64.1 \"x\"`,
+		"rtr.remote_address":    "10.0.0.1:54321",
+		"rtr.backend_address":   "10.0.1.2:61000",
+		"rtr.x_forwarded_for":   "203.0.113.9, 10.0.0.1",
+		"rtr.x_forwarded_proto": "https",
+		"rtr.vcap_request_id":   "a1b2c3d4-0000-4000-8000-000000000001",
+		"rtr.response_time":     0.012345,
+		"rtr.gorouter_time":     0.000123,
+		"rtr.app_id":            "app-1",
+		"rtr.app_index":         int64(2),
+		"rtr.instance_id":       "f00d",
+		"rtr.tenant":            "acme",
+		"trace.id":              "80f198ee56343ba864fe8b2a57d3eff7",
+		"span.id":               "e457b5a2e4d86bd1",
+		"parent.id":             "05e3ac9a4f6e3b90",
+	}, attrs.Marshal())
+
+	attrs = attributes.NewAttributes()
+	assert.True(t, (&gorouterParser{}).parse([]byte(`h - [t] "POST / HTTP/1.1" 502 0 67 "-" "-" "1.2.3.4:1" "-" x_b3_traceid:"abc" x_b3_spanid:"def"`), attrs))
+	assert.Equal(t, "abc", attrs.AttributeByName("trace.id").Value())
+	assert.Equal(t, "def", attrs.AttributeByName("span.id").Value())
+	assert.Nil(t, attrs.AttributeByName("rtr.backend_address"))
+
+	assert.False(t, (&gorouterParser{}).parse([]byte("router started"), attributes.NewAttributes()))
+}
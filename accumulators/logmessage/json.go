@@ -0,0 +1,140 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"sort"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
+)
+
+// promoted attributes and the JSON keys they are read from, flattened keys included.
+// Keys filtered out by the include and exclude globs are not promoted either.
+var promoted = []struct {
+	name string
+	keys []string
+}{
+	{"level", []string{"level", "log.level", "lvl", "severity", "loglevel"}},
+	{"trace.id", []string{"trace.id", "trace_id", "traceId", "traceid"}},
+	{"span.id", []string{"span.id", "span_id", "spanId", "spanid"}},
+}
+
+// jsonParser flattens JSON log payloads into prefixed attributes. Nested objects are
+// joined with dots, arrays and objects deeper than maxDepth are kept as JSON strings.
+type jsonParser struct {
+	prefix        string
+	maxDepth      int
+	maxAttributes int
+	include       []string
+	exclude       []string
+}
+
+// newJSONParser from LOGMESSAGE_JSON_*, nil when parsing is disabled.
+func newJSONParser(c *config.Config) *jsonParser {
+	if !c.GetBool("LOGMESSAGE_JSON") {
+		return nil
+	}
+	return &jsonParser{
+		prefix:        c.GetString("LOGMESSAGE_JSON_PREFIX"),
+		maxDepth:      c.GetInt("LOGMESSAGE_JSON_MAX_DEPTH"),
+		maxAttributes: c.GetInt("LOGMESSAGE_JSON_MAX_ATTRIBUTES"),
+		include:       c.GetFilter("LOGMESSAGE_JSON_INCLUDE_KEYS"),
+		exclude:       c.GetFilter("LOGMESSAGE_JSON_EXCLUDE_KEYS"),
+	}
+}
+
+// parse the payload into attrs, returning false when it isn't a JSON object.
+func (p *jsonParser) parse(payload []byte, attrs *attributes.Attributes) bool {
+	payload = bytes.TrimSpace(payload)
+	if len(payload) < 2 || payload[0] != '{' || payload[len(payload)-1] != '}' {
+		return false
+	}
+	var object map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	if err := d.Decode(&object); err != nil {
+		return false
+	}
+
+	flat := map[string]interface{}{}
+	p.flatten("", object, 1, flat)
+
+	for _, a := range promoted {
+		for _, key := range a.keys {
+			if v, ok := flat[key]; ok && p.allows(key) {
+				attrs.SetAttribute(a.name, v)
+				break
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		if p.allows(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if p.maxAttributes > 0 && len(keys) > p.maxAttributes {
+		keys = keys[:p.maxAttributes]
+		attrs.SetAttribute("log.json.truncated", true)
+	}
+	for _, key := range keys {
+		attrs.SetAttribute(p.prefix+key, flat[key])
+	}
+	return true
+}
+
+func (p *jsonParser) flatten(prefix string, object map[string]interface{}, depth int, flat map[string]interface{}) {
+	for k, v := range object {
+		key := prefix + k
+		switch value := v.(type) {
+		case map[string]interface{}:
+			if p.maxDepth > 0 && depth >= p.maxDepth {
+				flat[key] = jsonString(value)
+				continue
+			}
+			p.flatten(key+".", value, depth+1, flat)
+		case []interface{}:
+			flat[key] = jsonString(value)
+		case json.Number:
+			if i, err := value.Int64(); err == nil {
+				flat[key] = i
+			} else if f, err := value.Float64(); err == nil {
+				flat[key] = f
+			} else {
+				flat[key] = value.String()
+			}
+		case nil:
+		default:
+			flat[key] = value
+		}
+	}
+}
+
+// allows a flattened key through the include and exclude globs.
+func (p *jsonParser) allows(key string) bool {
+	if len(p.include) > 0 && !matchKey(p.include, key) {
+		return false
+	}
+	return !matchKey(p.exclude, key)
+}
+
+func matchKey(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonString(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
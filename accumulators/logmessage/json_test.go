@@ -0,0 +1,66 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"testing"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONParse(t *testing.T) {
+	p := &jsonParser{prefix: "log.json.", maxDepth: 2, maxAttributes: 10}
+	attrs := attributes.NewAttributes()
+	ok := p.parse([]byte(` {"lvl":"warn","msg":"slow","took":12,"ratio":0.5,"http":{"status":503,"req":{"id":"a"}},"tags":["x"],"trace":{"id":"abc"},"none":null}`), attrs)
+	assert.True(t, ok)
+
+	m := attrs.Marshal()
+	assert.Equal(t, "warn", m["level"])
+	assert.Equal(t, "abc", m["trace.id"])
+	assert.Equal(t, "slow", m["log.json.msg"])
+	assert.Equal(t, int64(12), m["log.json.took"])
+	assert.Equal(t, 0.5, m["log.json.ratio"])
+	assert.Equal(t, int64(503), m["log.json.http.status"])
+	assert.Equal(t, `{"id":"a"}`, m["log.json.http.req"])
+	assert.Equal(t, `["x"]`, m["log.json.tags"])
+	assert.NotContains(t, m, "log.json.none")
+
+	assert.False(t, p.parse([]byte("plain text"), attrs))
+	assert.False(t, p.parse([]byte("{not json}"), attrs))
+}
+
+func TestJSONLimits(t *testing.T) {
+	p := &jsonParser{prefix: "j.", maxAttributes: 2, exclude: []string{"secret*"}}
+	attrs := attributes.NewAttributes()
+	p.parse([]byte(`{"a":1,"b":2,"c":3,"secret_token":"x"}`), attrs)
+	m := attrs.Marshal()
+	assert.Contains(t, m, "j.a")
+	assert.Contains(t, m, "j.b")
+	assert.NotContains(t, m, "j.c")
+	assert.NotContains(t, m, "j.secret_token")
+	assert.Equal(t, true, m["log.json.truncated"])
+
+	p = &jsonParser{prefix: "j.", include: []string{"user.*"}}
+	attrs = attributes.NewAttributes()
+	p.parse([]byte(`{"user":{"id":7},"other":1}`), attrs)
+	m = attrs.Marshal()
+	assert.Equal(t, int64(7), m["j.user.id"])
+	assert.NotContains(t, m, "j.other")
+}
+
+func TestJSONPromotedKeysAreFiltered(t *testing.T) {
+	p := &jsonParser{prefix: "j.", exclude: []string{"trace_id", "lvl"}}
+	attrs := attributes.NewAttributes()
+	p.parse([]byte(`{"lvl":"debug","severity":"warn","trace_id":"abc","span_id":"def"}`), attrs)
+	m := attrs.Marshal()
+	assert.Equal(t, "warn", m["level"])
+	assert.NotContains(t, m, "trace.id")
+	assert.Equal(t, "def", m["span.id"])
+
+	p = &jsonParser{prefix: "j.", include: []string{"user.*"}}
+	attrs = attributes.NewAttributes()
+	p.parse([]byte(`{"user":{"id":7},"level":"info"}`), attrs)
+	assert.NotContains(t, attrs.Marshal(), "level")
+}
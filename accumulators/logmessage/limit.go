@@ -0,0 +1,130 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+)
+
+// Reasons a log message is dropped by the limiter.
+const (
+	droppedSampled   = "sampled"
+	droppedAppRate   = "app_rate"
+	droppedSpaceRate = "space_rate"
+)
+
+// limiter samples log messages and throttles noisy apps and spaces with token buckets
+// refilled at rate log messages per second, holding up to burst.
+type limiter struct {
+	sample     float64
+	appRate    float64
+	appBurst   float64
+	spaceRate  float64
+	spaceBurst float64
+	lock       *sync.Mutex
+	apps       map[string]*bucket
+	spaces     map[string]*bucket
+	pruned     time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter from LOGMESSAGE_SAMPLE_RATE and LOGMESSAGE_*_RATE|BURST, nil when it would
+// keep every log message.
+func newLimiter(c *config.Config) *limiter {
+	l := &limiter{
+		sample:     c.GetFloat64("LOGMESSAGE_SAMPLE_RATE"),
+		appRate:    c.GetFloat64("LOGMESSAGE_APP_RATE"),
+		appBurst:   c.GetFloat64("LOGMESSAGE_APP_BURST"),
+		spaceRate:  c.GetFloat64("LOGMESSAGE_SPACE_RATE"),
+		spaceBurst: c.GetFloat64("LOGMESSAGE_SPACE_BURST"),
+		lock:       &sync.Mutex{},
+		apps:       map[string]*bucket{},
+		spaces:     map[string]*bucket{},
+		pruned:     time.Now(),
+	}
+	if l.sample <= 0 || l.sample > 1 {
+		l.sample = 1
+	}
+	// A burst of at least a second of log messages.
+	if l.appBurst < l.appRate {
+		l.appBurst = l.appRate
+	}
+	if l.spaceBurst < l.spaceRate {
+		l.spaceBurst = l.spaceRate
+	}
+	if l.sample == 1 && l.appRate <= 0 && l.spaceRate <= 0 {
+		return nil
+	}
+	return l
+}
+
+// limitsSpaces reports whether space is needed by allow.
+func (l *limiter) limitsSpaces() bool {
+	return l.spaceRate > 0
+}
+
+// allow a log message of the app in space, returning why it is dropped otherwise. An
+// empty space isn't limited.
+func (l *limiter) allow(app string, space string) (bool, string) {
+	if l.sample < 1 && rand.Float64() >= l.sample {
+		return false, droppedSampled
+	}
+	if l.appRate <= 0 && l.spaceRate <= 0 {
+		return true, ""
+	}
+
+	now := time.Now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.prune(now)
+	if l.appRate > 0 && !take(l.apps, app, l.appRate, l.appBurst, now) {
+		return false, droppedAppRate
+	}
+	if l.spaceRate > 0 && space != "" && !take(l.spaces, space, l.spaceRate, l.spaceBurst, now) {
+		return false, droppedSpaceRate
+	}
+	return true, ""
+}
+
+// take a token from the bucket of key, creating a full one.
+func take(buckets map[string]*bucket, key string, rate float64, burst float64, now time.Time) bool {
+	b, found := buckets[key]
+	if !found {
+		b = &bucket{tokens: burst, last: now}
+		buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune buckets that have been idle for long, they would be full again.
+func (l *limiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < time.Minute {
+		return
+	}
+	l.pruned = now
+	for _, buckets := range []map[string]*bucket{l.apps, l.spaces} {
+		for key, b := range buckets {
+			if now.Sub(b.last) > 10*time.Minute {
+				delete(buckets, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func testLimiter(sample, appRate, spaceRate float64) *limiter {
+	return &limiter{
+		sample:     sample,
+		appRate:    appRate,
+		appBurst:   appRate,
+		spaceRate:  spaceRate,
+		spaceBurst: spaceRate,
+		lock:       &sync.Mutex{},
+		apps:       map[string]*bucket{},
+		spaces:     map[string]*bucket{},
+		pruned:     time.Now(),
+	}
+}
+
+func TestNewLimiter(t *testing.T) {
+	assert.Nil(t, newLimiter(config.Get().Scoped("limit")))
+
+	os.Setenv("NRF_ACC_LIMIT_LOGMESSAGE_APP_RATE", "10")
+	defer os.Unsetenv("NRF_ACC_LIMIT_LOGMESSAGE_APP_RATE")
+	l := newLimiter(config.Get().Scoped("limit"))
+	assert.NotNil(t, l)
+	assert.Equal(t, 1.0, l.sample)
+	assert.Equal(t, 10.0, l.appBurst)
+	assert.False(t, l.limitsSpaces())
+}
+
+func TestLimiterAppRate(t *testing.T) {
+	l := testLimiter(1, 2, 0)
+	for i := 0; i < 2; i++ {
+		ok, _ := l.allow("app", "space")
+		assert.True(t, ok)
+	}
+	ok, reason := l.allow("app", "space")
+	assert.False(t, ok)
+	assert.Equal(t, droppedAppRate, reason)
+
+	// Other apps have their own bucket.
+	ok, _ = l.allow("other", "space")
+	assert.True(t, ok)
+
+	// Refilled at rate tokens per second.
+	l.apps["app"].last = l.apps["app"].last.Add(-time.Second)
+	for i := 0; i < 2; i++ {
+		ok, _ := l.allow("app", "space")
+		assert.True(t, ok)
+	}
+	ok, _ = l.allow("app", "space")
+	assert.False(t, ok)
+}
+
+func TestLimiterSpaceRate(t *testing.T) {
+	l := testLimiter(1, 0, 1)
+	assert.True(t, l.limitsSpaces())
+	ok, _ := l.allow("app", "space")
+	assert.True(t, ok)
+	ok, reason := l.allow("other", "space")
+	assert.False(t, ok)
+	assert.Equal(t, droppedSpaceRate, reason)
+
+	// Apps with an unknown space aren't limited.
+	ok, _ = l.allow("other", "")
+	assert.True(t, ok)
+}
+
+func TestLimiterSample(t *testing.T) {
+	l := testLimiter(0.5, 0, 0)
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		if ok, reason := l.allow("app", ""); ok {
+			kept++
+		} else {
+			assert.Equal(t, droppedSampled, reason)
+		}
+	}
+	assert.InDelta(t, 5000, kept, 500)
+}
+
+func TestLimiterPrune(t *testing.T) {
+	l := testLimiter(1, 1, 0)
+	l.allow("app", "")
+	l.apps["app"].last = time.Now().Add(-time.Hour)
+	l.pruned = time.Now().Add(-time.Hour)
+	l.allow("other", "")
+	assert.NotContains(t, l.apps, "app")
+	assert.Contains(t, l.apps, "other")
+}
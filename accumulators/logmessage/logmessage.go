@@ -6,7 +6,6 @@ package logmessage
 import (
 	"context"
 	"strconv"
-	"strings"
 	"time"
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
@@ -24,50 +23,88 @@ import (
 // Firehose LogMessage Envelope Event Types
 type Nrevents struct {
 	accumulators.Accumulator
-	CFAppManager     *cfapps.CFAppManager
-	filtersEnabled   bool
-	logsEnabled      bool
-	sourceIncFilter  []string
-	sourceExcFilter  []string
-	messageIncFilter []string
-	messageExcFilter []string
+	CFAppManager *cfapps.CFAppManager
+	logsEnabled  bool
+	filter       *Filter
+	json         *jsonParser
+	gorouter     *gorouterParser
+	stitcher     *stitcher
+	limiter      *limiter
+	redactor     *accumulators.Redactor
+	oversize     string
 }
 
 // New satisfies event.Accumulator
-func (n Nrevents) New() accumulators.Interface {
+func (n Nrevents) New(c *config.Config) accumulators.Interface {
 	i := Nrevents{
 		Accumulator: accumulators.NewAccumulator(
+			c,
 			"*loggregator_v2.Envelope_Log",
 		),
 		CFAppManager: cfapps.GetInstance(),
 	}
-	i.sourceExcFilter = i.Config().GetFilter("LOGMESSAGE_SOURCE_EXCLUDE")
-	i.sourceIncFilter = i.Config().GetFilter("LOGMESSAGE_SOURCE_INCLUDE")
-	i.messageExcFilter = i.Config().GetFilter("LOGMESSAGE_MESSAGE_EXCLUDE")
-	i.messageIncFilter = i.Config().GetFilter("LOGMESSAGE_MESSAGE_INCLUDE")
-	i.filtersEnabled = i.AreFiltersEnabled()
+	filter, err := NewFilter(i.Config())
+	if err != nil {
+		app.Get().Log.Fatalf("invalid LogMessage filter: %s", err.Error())
+	}
+	i.filter = filter
+	i.json = newJSONParser(i.Config())
+	i.gorouter = newGorouterParser(i.Config())
+	i.limiter = newLimiter(i.Config())
+	redactor, err := accumulators.NewRedactor(
+		i.Config().GetString("REDACT_DETECTORS"),
+		i.Config().GetString("REDACT_RULES"),
+		i.Config().GetString("REDACT_HASH_KEY"),
+	)
+	if err != nil {
+		app.Get().Log.Fatalf("invalid redaction settings: %s", err.Error())
+	}
+	i.redactor = redactor
 	i.logsEnabled = i.Config().GetBool("LOGS_LOGMESSAGE")
+	i.oversize = i.Config().GetString("LOGMESSAGE_OVERSIZE")
+	if !validOversize(i.oversize) {
+		app.Get().Log.Fatalf("invalid LOGMESSAGE_OVERSIZE %q, want truncate, chunk or logs", i.oversize)
+	}
+	stitcher, err := newStitcher(i.Config(), i.process)
+	if err != nil {
+		app.Get().Log.Fatalf("invalid LogMessage multi-line settings: %s", err.Error())
+	}
+	i.stitcher = stitcher
 	return i
 }
 
 // Update satisfies event.Accumulator
 // func (n Nrevents) Update(e *events.Envelope) {
 func (n Nrevents) Update(e *loggregator_v2.Envelope) {
-	// Check filters first. Other work can be avoided if filters aren't matched
-	if n.filtersEnabled {
-		// Include filters will be checked first, then exclude filters
-		// Stop processing this envelope if the include filters are not matched
-		if n.IsIncluded(string(e.GetLog().Payload), e.Tags["source_type"]) == false {
-			return
-		}
+	// Multi-line records are filtered and sent once stitched.
+	if n.stitcher != nil {
+		n.stitcher.add(e)
+		return
+	}
+	n.process(e, 1)
+}
+
+// Flush satisfies accumulators.Flusher, sending the log messages still being stitched.
+func (n Nrevents) Flush() {
+	if n.stitcher != nil {
+		n.stitcher.close()
+	}
+}
 
-		// Stop processing this envelope if the exclude filters are matched
-		if n.IsExcluded(string(e.GetLog().Payload), e.Tags["source_type"]) == true {
+// process a log message made of lines envelopes.
+func (n Nrevents) process(e *loggregator_v2.Envelope, lines int) {
+	// Check filters first. Other work can be avoided if filters aren't matched
+	if n.filter != nil && !n.filter.Match(n.filterFields(e)) {
+		return
+	}
+	if n.limiter != nil {
+		if ok, reason := n.limiter.allow(e.GetSourceId(), n.spaceGUID(e)); !ok {
+			n.dropped(e, reason)
 			return
 		}
 	}
 
-	entity := n.GetEntity(e, nrpcf.GetPCFAttributes(e))
+	entity := n.GetEventEntity(e, nrpcf.GetPCFAttributes(e))
 
 	logEntry := attributes.NewAttributes()
 
@@ -77,10 +114,37 @@ func (n Nrevents) Update(e *loggregator_v2.Envelope) {
 	// msgContent := e.GetLogMessage().GetMessage()
 	msgContent := e.GetLog().Payload
 
+	// Redact before parsing so the parsed attributes are redacted too.
+	redacted, omit := n.redactor.Redact(string(msgContent), n.CountRedactions(e, "log.message"))
+	msgContent = []byte(redacted)
+
+	// Gorouter access logs are parsed instead of JSON, the parsed fields can replace the message.
+	parsed := false
+	if n.gorouter != nil && !omit && n.GetTag(e, "source_type") == "RTR" {
+		parsed = n.gorouter.parse(msgContent, logEntry)
+		if parsed && n.gorouter.parsedOnly {
+			omit, msgContent = true, nil
+		}
+	}
+	if n.json != nil && !omit && !parsed {
+		n.json.parse(msgContent, logEntry)
+	}
+	if lines > 1 {
+		logEntry.SetAttribute("log.message.lines", lines)
+	}
+	if n.limiter != nil && n.limiter.sample < 1 {
+		logEntry.SetAttribute("log.sample.rate", n.limiter.sample)
+	}
+
+	// Oversized messages can be diverted to NR Logs, which accepts them whole.
+	oversized := len(msgContent) > maxEventMessage
+
 	// Check to see if NR Logs is enabled for this accumulator
-	if n.logsEnabled {
+	if n.logsEnabled || (oversized && n.oversize == oversizeLogs) {
 		// Add log message attributes
-		logEntry.SetAttribute("message", string(msgContent))
+		if !omit {
+			logEntry.SetAttribute("message", string(msgContent))
+		}
 		// epoch timestamp from envelope converted to ms
 		logEntry.SetAttribute("timestamp", (e.GetTimestamp() / (int64(time.Millisecond) / int64(time.Nanosecond))))
 		logEntry.SetAttribute("app.id", e.GetSourceId())
@@ -91,18 +155,22 @@ func (n Nrevents) Update(e *loggregator_v2.Envelope) {
 		logEntry.AppendAll(entity.Attributes())
 		// Will need to determine what type of insert client is needed based on config.
 		// Some of the attributes above may not be needed for log messages.
-		client := nrpcf.GetLogClientForApp(entity)
+		client := nrpcf.GetLogClientForApp(entity, n.Config())
 		client.EnqueueLogEntry(context.Background(), logEntry.Marshal())
 		return
 	}
-	// Mesages over 4K in length will be rejected by the Event API.  Trim the message before sending.
-	if len(msgContent) > 4096 {
+	// Mesages over 4K in length will be rejected by the Event API.  Trim the message before sending,
+	// unless it is sent in chunks below.
+	chunked := oversized && n.oversize == oversizeChunk
+	if oversized && !chunked {
 		msgContent = msgContent[0:4095]
 		logEntry.SetAttribute("log.message.truncated", true)
 	}
 
 	// Add log message attributes
-	logEntry.SetAttribute("log.message", string(msgContent))
+	if !omit && !chunked {
+		logEntry.SetAttribute("log.message", string(msgContent))
+	}
 	// epoch timestamp from envelope converted to ms
 	ts := (e.GetTimestamp() / (int64(time.Millisecond) / int64(time.Nanosecond)))
 	logEntry.SetAttribute("timestamp", ts)
@@ -121,116 +189,95 @@ func (n Nrevents) Update(e *loggregator_v2.Envelope) {
 	logEntry.AppendAll(entity.Attributes())
 	// Will need to determine what type of insert client is needed based on config.
 	// Some of the attributes above may not be needed for log messages.
-	client := nrpcf.GetInsertClientForApp(entity)
+	client := nrpcf.GetInsertClientForApp(entity, n.Config())
+	if chunked {
+		sendChunks(client, logEntry.Marshal(), msgContent)
+		return
+	}
 	client.EnqueueEvent(context.Background(), logEntry.Marshal())
 }
 
-// HarvestMetrics - stub for LogMessages, which are all events...
-func (n Nrevents) HarvestMetrics(
-	entity *entities.Entity,
-	metric *metrics.Metric,
-) {
-}
-
-// AreFiltersEnabled populate struct to eliminate potentially unnecessary filter calls
-func (n Nrevents) AreFiltersEnabled() bool {
-	if n.sourceExcFilter == nil && n.sourceIncFilter == nil && n.messageExcFilter == nil && n.messageIncFilter == nil {
-		app.Get().Log.Debug("Log message filters disabled")
-		return false
+// spaceGUID of the app sending the log message, empty when unknown or not needed.
+func (n Nrevents) spaceGUID(e *loggregator_v2.Envelope) string {
+	if !n.limiter.limitsSpaces() {
+		return ""
 	}
-	app.Get().Log.Debug("Log message filters enabled")
-	return true
-}
-
-// IsIncluded ...
-func (n Nrevents) IsIncluded(
-	logMessage string,
-	logSource string,
-) bool {
-	matchFound := false
-	srcMatchFound := n.IsIncludedLogSource(logSource)
-	msgMatchFound := n.IsIncludedLogMessage(logMessage)
-	// If both a source and message include filter are set, both must be matched to include log message.
-	if srcMatchFound && msgMatchFound {
-		matchFound = true
+	a := n.CFAppManager.GetApp(e.GetSourceId())
+	a.Lock.RLock()
+	defer a.Lock.RUnlock()
+	if a.App == nil {
+		return ""
 	}
-	return matchFound
+	return a.App.SpaceGuid
 }
 
-// IsExcluded ...
-func (n Nrevents) IsExcluded(
-	logMessage string,
-	logSource string,
-) bool {
-	// First exclude filter is log source - if matched, exclude this log entry.
-	if n.IsExcludedLogSource(logSource) {
-		return true
-	}
-	// Second exclude filter is a message exclude - if matched, exclude this log entry.
-	if n.IsExcludedLogMessage(logMessage) {
-		return true
-	}
-	return false
+// dropped counts a log message dropped by the limiter, per app and reason.
+func (n Nrevents) dropped(e *loggregator_v2.Envelope, reason string) {
+	attrs := attributes.NewAttributes(
+		attributes.New(n.Config().AttributeName(config.EnvAppID), e.GetSourceId()),
+		attributes.New("log.dropped.reason", reason),
+	)
+	n.GetEntity(e, attrs).NewSample(
+		"log.dropped",
+		metrics.Types.Counter,
+		"messages",
+		1,
+	).Done()
 }
 
-// IsExcludedLogSource determines if envelopes with this log source should be dropped.
-func (n Nrevents) IsExcludedLogSource(
-	logSource string,
-) bool {
-	if n.sourceExcFilter != nil {
-		for _, filter := range n.sourceExcFilter {
-			if strings.Compare(strings.TrimSpace(filter), logSource) == 0 {
-				return true
-			}
-		}
+// HarvestMetrics sends the log messages dropped by the limiter and the redaction hits, the
+// log messages themselves are sent by Update.
+func (n Nrevents) HarvestMetrics(
+	entity *entities.Entity,
+	metric *metrics.Metric,
+) {
+	if metric.Name == accumulators.RedactionHits {
+		metric.SetAttribute("redaction.hit.count", int(metric.Sum))
+		metric.SetAttribute("eventType", n.Config().GetString(config.NewRelicEventTypeRedaction))
+	} else {
+		metric.SetAttribute("log.dropped.count", metric.Samples)
+		metric.SetAttribute("eventType", n.Config().GetString(config.NewRelicEventTypeLogDropped))
 	}
-	return false
-}
+	metric.SetAttribute("agent.subscription", n.Config().GetString("FIREHOSE_ID"))
+	metric.SetAttribute(n.Config().AttributeName(config.EnvDomain), nrpcf.PCFDomain())
 
-// IsExcludedLogMessage determines if envelopes with this log message should be dropped.
-func (n Nrevents) IsExcludedLogMessage(
-	logMessage string,
-) bool {
-	if n.messageExcFilter != nil {
-		for _, filter := range n.messageExcFilter {
-			if strings.Contains(logMessage, strings.TrimSpace(filter)) {
-				return true
-			}
-		}
+	metric.Attributes().
+		AppendAll(entity.Attributes())
+
+	if guid := entity.AttributeByName(n.Config().AttributeName(config.EnvAppID)); guid != nil {
+		metric.Attributes().AppendAll(nrpcf.GetAppAttributes(guid.Value().(string)))
 	}
-	return false
+
+	client := nrpcf.GetInsertClientForApp(entity, n.Config())
+	client.EnqueueEvent(context.Background(), metric.Marshal())
 }
 
-// IsIncludedLogSource determines if envelopes with this log source should be included.
-func (n Nrevents) IsIncludedLogSource(
-	logSource string,
-) bool {
-	if n.sourceIncFilter != nil {
-		for _, filter := range n.sourceIncFilter {
-			if strings.Compare(strings.TrimSpace(filter), logSource) == 0 {
-				return true
-			}
-		}
-	} else {
-		return true
+// filterFields of the log message, the app names are looked up when the filter compares them.
+func (n Nrevents) filterFields(e *loggregator_v2.Envelope) *fields {
+	return &fields{
+		values: map[string]string{
+			"app_id":       e.GetSourceId(),
+			"source_type":  n.GetTag(e, "source_type"),
+			"message_type": n.getLogMessageType(e.GetLog()),
+			"message":      string(e.GetLog().GetPayload()),
+		},
+		app: func() (name, org, space string) {
+			a := n.CFAppManager.GetApp(e.GetSourceId())
+			a.Lock.RLock()
+			defer a.Lock.RUnlock()
+			return attributeString(a.Attributes.Has(cfapps.AppName)),
+				attributeString(a.Attributes.Has(cfapps.AppOrgName)),
+				attributeString(a.Attributes.Has(cfapps.AppSpaceName))
+		},
 	}
-	return false
 }
 
-// IsIncludedLogMessage determines if envelopes with this log message should be included.
-func (n Nrevents) IsIncludedLogMessage(
-	logMessage string,
-) bool {
-	if n.messageIncFilter != nil {
-		for _, filter := range n.messageIncFilter {
-			if strings.Contains(logMessage, strings.TrimSpace(filter)) {
-				return true
-			}
-		}
-	} else {
-		return true
+func attributeString(a *attributes.Attribute) string {
+	if a == nil {
+		return ""
 	}
-	return false
+	s, _ := a.Value().(string)
+	return s
 }
 
 // ConvertSourceInstance from a string to int32
@@ -0,0 +1,53 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/cfapps"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/entities"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrclients"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessOverCardinalityLimit(t *testing.T) {
+	os.Setenv("NRF_ACC_LOGCARD_CARDINALITY_MAX_ENTITIES", "1")
+	defer os.Unsetenv("NRF_ACC_LOGCARD_CARDINALITY_MAX_ENTITIES")
+	cfapps.StartOffline(app.Get())
+	var sink bytes.Buffer
+	nrclients.New().SetSink(nrclients.NewWriterClient(&sink))
+	defer nrclients.New().SetSink(nil)
+
+	n := Nrevents{}.New(config.Get().Scoped("logcard")).(Nrevents)
+	// The dropped messages metric takes the only entity allowed.
+	n.dropped(logLine("0", "dropped"), "rate")
+
+	e := logLine("1", "hello")
+	e.Tags["deployment"] = "cf"
+	n.process(e, 1)
+	nrclients.New().FlushAll()
+
+	var logs []map[string]interface{}
+	for d := json.NewDecoder(&sink); d.More(); {
+		var event map[string]interface{}
+		assert.NoError(t, d.Decode(&event))
+		logs = append(logs, event)
+	}
+	if assert.Len(t, logs, 1) {
+		assert.Equal(t, "hello", logs[0]["log.message"])
+		assert.Equal(t, "app", logs[0][config.Get().AttributeName(config.EnvAppID)])
+		assert.Equal(t, "cf", logs[0][config.Get().AttributeName(config.EnvDeployment)])
+		assert.NotContains(t, logs[0], "cardinality.entity")
+	}
+	// Log messages hold no metrics and are not kept for the harvest.
+	for _, entity := range n.Drain() {
+		assert.NotEqual(t, entities.Overflow, entity.Attributes().Marshal()["cardinality.entity"])
+	}
+}
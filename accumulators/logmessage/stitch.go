@@ -0,0 +1,164 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+)
+
+// stitcher joins the lines of multi-line records, such as stack traces, that arrive as one
+// envelope per line. Lines are buffered per app instance, source and stream until a line
+// starting a new record arrives or the record has not grown for the flush timeout.
+type stitcher struct {
+	start    *regexp.Regexp
+	cont     *regexp.Regexp
+	timeout  time.Duration
+	maxLines int
+	lock     *sync.Mutex
+	records  map[string]*record
+	emit     func(e *loggregator_v2.Envelope, lines int)
+	stop     chan struct{}
+	stopped  *sync.Once
+}
+
+type record struct {
+	envelope *loggregator_v2.Envelope
+	lines    [][]byte
+	updated  time.Time
+}
+
+// newStitcher from LOGMESSAGE_MULTILINE_*, nil when stitching is disabled. Stitched records
+// are passed to emit.
+func newStitcher(c *config.Config, emit func(e *loggregator_v2.Envelope, lines int)) (*stitcher, error) {
+	if !c.GetBool("LOGMESSAGE_MULTILINE") {
+		return nil, nil
+	}
+	start, err := regexp.Compile(c.GetString("LOGMESSAGE_MULTILINE_START"))
+	if err != nil {
+		return nil, fmt.Errorf("NRF_LOGMESSAGE_MULTILINE_START: %s", err.Error())
+	}
+	var cont *regexp.Regexp
+	if s := c.GetString("LOGMESSAGE_MULTILINE_CONTINUE"); s != "" {
+		if cont, err = regexp.Compile(s); err != nil {
+			return nil, fmt.Errorf("NRF_LOGMESSAGE_MULTILINE_CONTINUE: %s", err.Error())
+		}
+	}
+	s := &stitcher{
+		start:    start,
+		cont:     cont,
+		timeout:  time.Duration(c.GetInt("LOGMESSAGE_MULTILINE_TIMEOUT_MS")) * time.Millisecond,
+		maxLines: c.GetInt("LOGMESSAGE_MULTILINE_MAX_LINES"),
+		lock:     &sync.Mutex{},
+		records:  map[string]*record{},
+		emit:     emit,
+		stop:     make(chan struct{}),
+		stopped:  &sync.Once{},
+	}
+	go s.flushLoop()
+	return s, nil
+}
+
+// add a line, emitting the buffered record of the same stream when the line starts a new one.
+func (s *stitcher) add(e *loggregator_v2.Envelope) {
+	key := fmt.Sprintf("%s/%s/%s/%d", e.GetSourceId(), e.GetInstanceId(), e.GetTags()["source_type"], e.GetLog().GetType())
+	line := e.GetLog().GetPayload()
+
+	s.lock.Lock()
+	r, found := s.records[key]
+	if found && !s.startsRecord(line) && (s.maxLines <= 0 || len(r.lines) < s.maxLines) {
+		r.lines = append(r.lines, line)
+		r.updated = time.Now()
+		s.lock.Unlock()
+		return
+	}
+	s.records[key] = &record{envelope: e, lines: [][]byte{line}, updated: time.Now()}
+	s.lock.Unlock()
+
+	if found {
+		s.flush(r)
+	}
+}
+
+// startsRecord unless the line matches the continuation pattern or not the start pattern.
+func (s *stitcher) startsRecord(line []byte) bool {
+	if s.cont != nil && s.cont.Match(line) {
+		return false
+	}
+	return s.start.Match(line)
+}
+
+// close stops the flush loop and emits every buffered record.
+func (s *stitcher) close() {
+	s.stopped.Do(func() { close(s.stop) })
+	s.flushAll()
+}
+
+// flushAll emits every buffered record.
+func (s *stitcher) flushAll() {
+	s.lock.Lock()
+	records := s.records
+	s.records = map[string]*record{}
+	s.lock.Unlock()
+	for _, r := range records {
+		s.flush(r)
+	}
+}
+
+// flushLoop emits records that have not grown for the timeout, until the stitcher is closed.
+func (s *stitcher) flushLoop() {
+	interval := s.timeout / 2
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		var expired []*record
+		s.lock.Lock()
+		for key, r := range s.records {
+			if time.Since(r.updated) >= s.timeout {
+				expired = append(expired, r)
+				delete(s.records, key)
+			}
+		}
+		s.lock.Unlock()
+		for _, r := range expired {
+			s.flush(r)
+		}
+	}
+}
+
+// flush emits the record as a single envelope with the timestamp and tags of its first line.
+func (s *stitcher) flush(r *record) {
+	if len(r.lines) == 1 {
+		s.emit(r.envelope, 1)
+		return
+	}
+	e := r.envelope
+	s.emit(&loggregator_v2.Envelope{
+		Timestamp:      e.GetTimestamp(),
+		SourceId:       e.GetSourceId(),
+		InstanceId:     e.GetInstanceId(),
+		DeprecatedTags: e.GetDeprecatedTags(),
+		Tags:           e.GetTags(),
+		Message: &loggregator_v2.Envelope_Log{
+			Log: &loggregator_v2.Log{
+				Payload: bytes.Join(r.lines, []byte("\n")),
+				Type:    e.GetLog().GetType(),
+			},
+		},
+	}, len(r.lines))
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logmessage
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type emitted struct {
+	lock    sync.Mutex
+	records []string
+	lines   []int
+}
+
+func (e *emitted) emit(env *loggregator_v2.Envelope, lines int) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.records = append(e.records, string(env.GetLog().GetPayload()))
+	e.lines = append(e.lines, lines)
+}
+
+func (e *emitted) get() ([]string, []int) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]string{}, e.records...), append([]int{}, e.lines...)
+}
+
+func logLine(instance string, payload string) *loggregator_v2.Envelope {
+	return &loggregator_v2.Envelope{
+		SourceId:   "app",
+		InstanceId: instance,
+		Tags:       map[string]string{"source_type": "APP/PROC/WEB"},
+		Message:    &loggregator_v2.Envelope_Log{Log: &loggregator_v2.Log{Payload: []byte(payload)}},
+	}
+}
+
+func testStitcher(t *testing.T, e *emitted) *stitcher {
+	os.Setenv("NRF_LOGMESSAGE_MULTILINE", "true")
+	os.Setenv("NRF_LOGMESSAGE_MULTILINE_TIMEOUT_MS", "50")
+	defer os.Unsetenv("NRF_LOGMESSAGE_MULTILINE")
+	defer os.Unsetenv("NRF_LOGMESSAGE_MULTILINE_TIMEOUT_MS")
+	s, err := newStitcher(config.Get().Scoped("stitch"), e.emit)
+	assert.NoError(t, err)
+	return s
+}
+
+func TestStitchStackTrace(t *testing.T) {
+	e := &emitted{}
+	s := testStitcher(t, e)
+	for _, l := range []string{
+		"java.lang.IllegalStateException: boom",
+		"\tat com.example.Orders.place(Orders.java:42)",
+		"Caused by: java.io.IOException: closed",
+		"\t... 12 more",
+		"INFO next record",
+	} {
+		s.add(logLine("0", l))
+	}
+	// Another instance is stitched separately.
+	s.add(logLine("1", "\tat orphan"))
+
+	records, lines := e.get()
+	assert.Equal(t, []string{"java.lang.IllegalStateException: boom\n\tat com.example.Orders.place(Orders.java:42)\nCaused by: java.io.IOException: closed\n\t... 12 more"}, records)
+	assert.Equal(t, []int{4}, lines)
+
+	// The last records are flushed after the timeout.
+	assert.Eventually(t, func() bool {
+		records, _ := e.get()
+		return len(records) == 3
+	}, time.Second, 10*time.Millisecond)
+	records, lines = e.get()
+	assert.ElementsMatch(t, []string{"INFO next record", "\tat orphan"}, records[1:])
+	assert.Equal(t, []int{1, 1}, lines[1:])
+}
+
+func TestStitchClose(t *testing.T) {
+	e := &emitted{}
+	s := testStitcher(t, e)
+	s.add(logLine("0", "first"))
+	s.close()
+	records, _ := e.get()
+	assert.Equal(t, []string{"first"}, records)
+
+	// The flush loop is stopped, records are only emitted by closing again.
+	s.add(logLine("0", "late"))
+	time.Sleep(150 * time.Millisecond)
+	records, _ = e.get()
+	assert.Len(t, records, 1)
+	s.close()
+	records, _ = e.get()
+	assert.Equal(t, []string{"first", "late"}, records)
+}
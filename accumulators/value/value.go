@@ -20,30 +20,57 @@ import (
 // Firehose ContainerMetric Envelope Event Types
 type Metrics struct {
 	accumulators.Accumulator
+	gaugeType metrics.Type
+	filter    *accumulators.MetricFilter
 }
 
 // New satisfies metric.Accumulator
-func (m Metrics) New() accumulators.Interface {
+func (m Metrics) New(c *config.Config) accumulators.Interface {
 	i := Metrics{
 		Accumulator: accumulators.NewAccumulator(
+			c,
 			// Does not match a v2 envelope type, but router will send appropriate envelopes here.
 			"ValueMetric",
 		),
+		gaugeType: metrics.Types.Gauge,
 	}
+	if i.Config().GetBool("METRICS_DISTRIBUTION") {
+		i.gaugeType = metrics.Types.Distribution
+	}
+	filter, err := accumulators.NewMetricFilter(
+		i.Config().GetString("VALUEMETRIC_INCLUDE"),
+		i.Config().GetString("VALUEMETRIC_EXCLUDE"),
+	)
+	if err != nil {
+		app.Get().Log.Fatalf("invalid ValueMetric filter: %s", err.Error())
+	}
+	i.filter = filter
 	return i
 }
 
 // Update satisfies metric.Accumulator
 func (m Metrics) Update(e *loggregator_v2.Envelope) {
 
-	ent := m.GetEntity(e, nrpcf.GetPCFAttributes(e))
 	g := e.GetGauge()
+	// Filter before the entity is created, envelopes without a metric left are dropped.
+	keys := make([]string, 0, len(g.Metrics))
+	for key := range g.Metrics {
+		if m.filter.Allows(e, key) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	ent := m.GetEntity(e, nrpcf.GetPCFAttributes(e))
 	// A single v2 envelope can contain multiple metrics.
-	for key, met := range g.Metrics {
+	for _, key := range keys {
+		met := g.Metrics[key]
 		ent.
 			NewSample(
 				key,
-				metrics.Types.Gauge,
+				m.gaugeType,
 				met.GetUnit(),
 				met.GetValue(),
 			).
@@ -67,7 +94,7 @@ func (m Metrics) HarvestMetrics(
 	metric.Attributes().AppendAll(entity.Attributes())
 
 	// Get a client with the insert key and RPM account ID from the config.
-	client := nrclients.New().GetEventClient(app.Get().Config.GetNewRelicConfig())
+	client := nrclients.New().GetEventClient(m.Config().GetNewRelicConfig())
 	client.EnqueueEvent(context.Background(), metric.Marshal())
 
 }
@@ -4,6 +4,10 @@
 package api
 
 import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
 	cfclient "github.com/cloudfoundry-community/go-cfclient"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
 )
@@ -21,11 +25,17 @@ func New() (c *Client, err error) {
 
 	c = &Client{}
 
+	tlsConfig, err := config.GetTLSConfig()
+	if err != nil {
+		return c, err
+	}
+
 	c.Client, err = cfclient.NewClient(&cfclient.Config{
 		ApiAddress:        config.GetString("CF_API_URL"),
 		ClientID:          config.GetString("CF_CLIENT_ID"),
 		ClientSecret:      config.GetString("CF_CLIENT_SECRET"),
 		SkipSslValidation: config.GetBool("CF_SKIP_SSL"),
+		HttpClient:        NewHTTPClient(tlsConfig),
 	})
 
 	if err != nil {
@@ -36,9 +46,21 @@ func New() (c *Client, err error) {
 		config.GetString("CF_API_UAA_URL"),
 		config.GetString("CF_CLIENT_ID"),
 		config.GetString("CF_CLIENT_SECRET"),
-		config.GetBool("CF_SKIP_SSL"),
+		tlsConfig,
 	)
 
 	return c, err
 
 }
+
+// NewHTTPClient for the CF API using tlsConfig. go-cfclient also fetches its UAA tokens
+// through this client.
+func NewHTTPClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     tlsConfig,
+		},
+	}
+}
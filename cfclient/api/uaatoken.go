@@ -4,44 +4,53 @@
 package api
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
-	"github.com/cloudfoundry-incubator/uaago"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
 )
 
 // UAATokenRefresher ...
 type UAATokenRefresher struct {
-	url               string
-	clientID          string
-	clientSecret      string
-	skipSSLValidation bool
-	client            *uaago.Client
+	url          string
+	clientID     string
+	clientSecret string
+	client       *http.Client
 }
 
-// NewUAATokenRefresher ...
+// NewUAATokenRefresher requesting client credentials tokens from authEndpoint over tlsConfig.
 func NewUAATokenRefresher(authEndpoint string,
 	clientID string,
 	clientSecret string,
-	skipSSLValidation bool,
+	tlsConfig *tls.Config,
 ) (*UAATokenRefresher, error) {
-	client, err := uaago.NewClient(authEndpoint)
+	u, err := url.Parse(authEndpoint)
 	if err != nil {
 		return &UAATokenRefresher{}, err
 	}
 
 	return &UAATokenRefresher{
-		url:               authEndpoint,
-		clientID:          clientID,
-		clientSecret:      clientSecret,
-		skipSSLValidation: skipSSLValidation,
-		client:            client,
+		url:          strings.TrimRight(u.String(), "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
 	}, nil
 }
 
 // RefreshAuthToken ...
 func (uaa *UAATokenRefresher) RefreshAuthToken() (string, error) {
-	authToken, err := uaa.client.GetAuthToken(uaa.clientID, uaa.clientSecret, uaa.skipSSLValidation)
+	authToken, err := uaa.getAuthToken()
 	if err != nil {
 		app.Get().Log.Error(
 			fmt.Sprintf(
@@ -52,3 +61,35 @@ func (uaa *UAATokenRefresher) RefreshAuthToken() (string, error) {
 	}
 	return authToken, nil
 }
+
+// getAuthToken requests a client credentials token, returned as "<type> <token>".
+func (uaa *UAATokenRefresher) getAuthToken() (string, error) {
+	data := url.Values{
+		"client_id":  {uaa.clientID},
+		"grant_type": {"client_credentials"},
+	}
+	req, err := http.NewRequest(http.MethodPost, uaa.url+"/oauth/token", strings.NewReader(data.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(uaa.clientID, uaa.clientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := uaa.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Received a status code %v", resp.Status)
+	}
+
+	var token struct {
+		TokenType   string `json:"token_type"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s", token.TokenType, token.AccessToken), nil
+}
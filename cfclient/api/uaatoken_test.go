@@ -0,0 +1,71 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// uaaServer answers token requests with the status and body, checking the request.
+func uaaServer(t *testing.T, status int, body string) (*httptest.Server, *tls.Config) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/oauth/token", r.URL.Path)
+		id, secret, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "nozzle", id)
+		assert.Equal(t, "s3cret", secret)
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "nozzle", r.PostForm.Get("client_id"))
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	return srv, &tls.Config{RootCAs: roots}
+}
+
+func TestGetAuthToken(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		status int
+		body   string
+		token  string
+		err    string
+	}{
+		{"success", http.StatusOK, `{"token_type":"bearer","access_token":"abc","expires_in":599}`, "bearer abc", ""},
+		{"unauthorized", http.StatusUnauthorized, `{"error":"unauthorized"}`, "", "401"},
+		{"malformed", http.StatusOK, `{"access_token":`, "", "unexpected EOF"},
+	} {
+		srv, tlsConfig := uaaServer(t, test.status, test.body)
+		uaa, err := NewUAATokenRefresher(srv.URL+"/", "nozzle", "s3cret", tlsConfig)
+		assert.NoError(t, err)
+		token, err := uaa.getAuthToken()
+		srv.Close()
+		if test.err != "" {
+			if assert.Error(t, err, test.name) {
+				assert.Contains(t, err.Error(), test.err, test.name)
+			}
+			continue
+		}
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.token, token, test.name)
+	}
+}
+
+func TestGetAuthTokenVerifiesTheServer(t *testing.T) {
+	srv, _ := uaaServer(t, http.StatusOK, `{"token_type":"bearer","access_token":"abc"}`)
+	defer srv.Close()
+	uaa, err := NewUAATokenRefresher(srv.URL, "nozzle", "s3cret", &tls.Config{RootCAs: x509.NewCertPool()})
+	assert.NoError(t, err)
+	_, err = uaa.getAuthToken()
+	assert.Error(t, err)
+}
@@ -101,7 +101,7 @@ func (a *CFApp) UpdateInstances() {
 	if err != nil {
 		if strings.Contains(err.Error(), "401 Unauthorized") {
 			//401 unauthorized -- token has expired so we need to refresh the client
-			app.Get().Log.Warn("cfClient 401 error. Refreshing client due to this error: %s", err.Error())
+			app.Get().Log.Warnf("cfClient 401 error. Refreshing client due to this error: %s", err.Error())
 			go GetInstance().UpdateClient()
 		}
 		if _, found := a.Summaries[0]; found {
@@ -141,7 +141,7 @@ func (a *CFApp) GetAppEnv() {
 		app.Get().Log.Errorf("GetAppEnv failed: %v", err)
 		if strings.Contains(err.Error(), "401 Unauthorized") {
 			//401 unauthorized -- token has expired so we need to refresh the client
-			app.Get().Log.Warn("cfClient 401 error. Refreshing client due to this error: %s", err.Error())
+			app.Get().Log.Warnf("cfClient 401 error. Refreshing client due to this error: %s", err.Error())
 			go GetInstance().UpdateClient()
 		}
 		return
@@ -13,6 +13,7 @@ import (
 
 	cfclient "github.com/cloudfoundry-community/go-cfclient"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/api"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
 )
 
@@ -52,6 +53,22 @@ func Start(app *app.Application) *CFAppManager {
 	return instance
 }
 
+// StartOffline starts a CFAppManager without a CF API client. App details are never
+// fetched, so app attributes keep their placeholder values. Used when replaying recordings.
+func StartOffline(app *app.Application) *CFAppManager {
+
+	once.Do(func() {
+		app.Log.Info("started offline CFAppManager")
+		instance = &CFAppManager{
+			app:         app,
+			clientLock:  &sync.RWMutex{},
+			Cache:       NewCache(),
+			rateManager: newRateManager(),
+		}
+	})
+	return instance
+}
+
 // GetInstance gets singleton of CFAppManager
 func GetInstance() *CFAppManager {
 	return instance
@@ -69,6 +86,10 @@ func (c *CFAppManager) GetApp(guid string) (app *CFApp) {
 		return app
 	}
 	app = NewCFApp(guid)
+	if c.client == nil {
+		// Offline, there is no CF API to fetch the app details from.
+		return app
+	}
 	c.app.Log.Debug("Adding new app: ", guid)
 	c.Cache.Put(app)
 	return app
@@ -126,7 +147,7 @@ func (c *CFAppManager) FetchApp(a *CFApp) error {
 		c.app.Log.Warn(err)
 		if strings.Contains(err.Error(), "401 Unauthorized") {
 			//401 unauthorized -- token has expired so we need to refresh the client
-			app.Get().Log.Warn("cfClient 401 error. Refreshing client due to this error: %s", err.Error())
+			app.Get().Log.Warnf("cfClient 401 error. Refreshing client due to this error: %s", err.Error())
 			go c.UpdateClient()
 		}
 		return err
@@ -162,11 +183,16 @@ func (c *CFAppManager) Close() {
 }
 
 func newClient(app *app.Application) *cfclient.Client {
+	tlsConfig, err := app.Config.GetTLSConfig()
+	if err != nil {
+		app.Log.Fatalf("invalid TLS configuration: %s", err.Error())
+	}
 	config := &cfclient.Config{
 		ApiAddress:        app.Config.GetString("CF_API_URL"),
 		Username:          app.Config.GetString("CF_API_USERNAME"),
 		Password:          app.Config.GetString("CF_API_PASSWORD"),
 		SkipSslValidation: app.Config.GetBool("CF_SKIP_SSL"),
+		HttpClient:        api.NewHTTPClient(tlsConfig),
 	}
 
 	client, err := cfclient.NewClient(config)
@@ -0,0 +1,140 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cfapps
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	cfclient "github.com/cloudfoundry-community/go-cfclient"
+)
+
+// ResolveSourceIDs returns the GUIDs of every app in the named orgs and spaces plus the
+// named apps. Spaces can be given as "space" or "org/space" and apps as "app",
+// "space/app" or "org/space/app". Names without an org match in every org.
+func (c *CFAppManager) ResolveSourceIDs(orgs []string, spaces []string, apps []string) ([]string, error) {
+	c.clientLock.RLock()
+	defer c.clientLock.RUnlock()
+
+	guids := map[string]bool{}
+	add := func(list []cfclient.App) {
+		for _, a := range list {
+			guids[a.Guid] = true
+		}
+	}
+
+	for _, name := range orgs {
+		orgGUIDs, err := c.orgGUIDs(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		for _, org := range orgGUIDs {
+			list, err := c.client.ListAppsByQuery(url.Values{"q": {"organization_guid:" + org}})
+			if err != nil {
+				return nil, err
+			}
+			add(list)
+		}
+	}
+
+	for _, name := range spaces {
+		spaceGUIDs, err := c.spaceGUIDs(strings.Split(strings.TrimSpace(name), "/"))
+		if err != nil {
+			return nil, err
+		}
+		for _, space := range spaceGUIDs {
+			list, err := c.client.ListAppsByQuery(url.Values{"q": {"space_guid:" + space}})
+			if err != nil {
+				return nil, err
+			}
+			add(list)
+		}
+	}
+
+	for _, name := range apps {
+		path := strings.Split(strings.TrimSpace(name), "/")
+		appName := path[len(path)-1]
+		if len(path) == 1 {
+			list, err := c.client.ListAppsByQuery(url.Values{"q": {"name:" + appName}})
+			if err != nil {
+				return nil, err
+			}
+			add(list)
+			continue
+		}
+		spaceGUIDs, err := c.spaceGUIDs(path[:len(path)-1])
+		if err != nil {
+			return nil, err
+		}
+		for _, space := range spaceGUIDs {
+			list, err := c.client.ListAppsByQuery(url.Values{"q": {"name:" + appName, "space_guid:" + space}})
+			if err != nil {
+				return nil, err
+			}
+			add(list)
+		}
+	}
+
+	ids := make([]string, 0, len(guids))
+	for guid := range guids {
+		ids = append(ids, guid)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (c *CFAppManager) orgGUIDs(name string) ([]string, error) {
+	orgs, err := c.client.ListOrgsByQuery(url.Values{"q": {"name:" + name}})
+	if err != nil {
+		return nil, err
+	}
+	if len(orgs) == 0 {
+		c.app.Log.Warnf("org %s not found", name)
+	}
+	guids := make([]string, 0, len(orgs))
+	for _, o := range orgs {
+		guids = append(guids, o.Guid)
+	}
+	return guids, nil
+}
+
+// spaceGUIDs resolves a ["space"] or ["org", "space"] path.
+func (c *CFAppManager) spaceGUIDs(path []string) ([]string, error) {
+	switch len(path) {
+	case 1:
+		return c.querySpaces(url.Values{"q": {"name:" + path[0]}})
+	case 2:
+		orgGUIDs, err := c.orgGUIDs(path[0])
+		if err != nil {
+			return nil, err
+		}
+		var guids []string
+		for _, org := range orgGUIDs {
+			spaces, err := c.querySpaces(url.Values{"q": {"name:" + path[1], "organization_guid:" + org}})
+			if err != nil {
+				return nil, err
+			}
+			guids = append(guids, spaces...)
+		}
+		return guids, nil
+	}
+	return nil, fmt.Errorf("invalid space %q, expected space or org/space", strings.Join(path, "/"))
+}
+
+func (c *CFAppManager) querySpaces(q url.Values) ([]string, error) {
+	spaces, err := c.client.ListSpacesByQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(spaces) == 0 {
+		c.app.Log.Warnf("space %s not found", q.Get("q"))
+	}
+	guids := make([]string, 0, len(spaces))
+	for _, s := range spaces {
+		guids = append(guids, s.Guid)
+	}
+	return guids, nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cfapps
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	cfclient "github.com/cloudfoundry-community/go-cfclient"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+	"github.com/stretchr/testify/assert"
+)
+
+// cfAPI serves the orgs, spaces and apps queries of the CF API v2.
+type cfAPI struct {
+	orgs   map[string]string   // org name: guid
+	spaces map[string][]string // space name: org guid, space guid pairs
+	apps   map[string][]string // space guid: app name, app guid pairs
+}
+
+func (c *cfAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := map[string]string{}
+	for _, f := range r.URL.Query()["q"] {
+		kv := strings.SplitN(f, ":", 2)
+		q[kv[0]] = kv[1]
+	}
+	var resources []string
+	resource := func(guid string, entity string) {
+		resources = append(resources, fmt.Sprintf(`{"metadata":{"guid":%q},"entity":%s}`, guid, entity))
+	}
+	switch r.URL.Path {
+	case "/v2/organizations":
+		if guid, ok := c.orgs[q["name"]]; ok {
+			resource(guid, fmt.Sprintf(`{"name":%q}`, q["name"]))
+		}
+	case "/v2/spaces":
+		pairs := c.spaces[q["name"]]
+		for i := 0; i < len(pairs); i += 2 {
+			if org, ok := q["organization_guid"]; !ok || org == pairs[i] {
+				resource(pairs[i+1], fmt.Sprintf(`{"name":%q}`, q["name"]))
+			}
+		}
+	case "/v2/apps":
+		for space, pairs := range c.apps {
+			for i := 0; i < len(pairs); i += 2 {
+				if (q["name"] == "" || q["name"] == pairs[i]) &&
+					(q["space_guid"] == "" || q["space_guid"] == space) &&
+					(q["organization_guid"] == "" || q["organization_guid"] == c.spaceOrg(space)) {
+					resource(pairs[i+1], fmt.Sprintf(`{"name":%q,"space_guid":%q}`, pairs[i], space))
+				}
+			}
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	sort.Strings(resources)
+	fmt.Fprintf(w, `{"total_results":%d,"resources":[%s]}`, len(resources), strings.Join(resources, ","))
+}
+
+// spaceOrg returns the org guid of the space.
+func (c *cfAPI) spaceOrg(space string) string {
+	for _, pairs := range c.spaces {
+		for i := 0; i < len(pairs); i += 2 {
+			if pairs[i+1] == space {
+				return pairs[i]
+			}
+		}
+	}
+	return ""
+}
+
+func newTestManager(t *testing.T) *CFAppManager {
+	api := &cfAPI{
+		orgs: map[string]string{"prod": "org-prod", "dev": "org-dev"},
+		spaces: map[string][]string{
+			"web":  {"org-prod", "space-prod-web", "org-dev", "space-dev-web"},
+			"jobs": {"org-prod", "space-prod-jobs"},
+		},
+		apps: map[string][]string{
+			"space-prod-web":  {"store", "app-prod-store", "admin", "app-prod-admin"},
+			"space-prod-jobs": {"billing", "app-prod-billing"},
+			"space-dev-web":   {"store", "app-dev-store"},
+		},
+	}
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	return &CFAppManager{
+		app:        app.Get(),
+		client:     &cfclient.Client{Config: cfclient.Config{ApiAddress: srv.URL, HttpClient: srv.Client()}},
+		clientLock: &sync.RWMutex{},
+	}
+}
+
+func TestResolveSourceIDs(t *testing.T) {
+	c := newTestManager(t)
+	for _, tc := range []struct {
+		orgs, spaces, apps []string
+		want               []string
+	}{
+		{orgs: []string{"prod"}, want: []string{"app-prod-admin", "app-prod-billing", "app-prod-store"}},
+		{spaces: []string{"web"}, want: []string{"app-dev-store", "app-prod-admin", "app-prod-store"}},
+		{spaces: []string{"prod/web"}, want: []string{"app-prod-admin", "app-prod-store"}},
+		{apps: []string{"store"}, want: []string{"app-dev-store", "app-prod-store"}},
+		{apps: []string{"dev/web/store"}, want: []string{"app-dev-store"}},
+		{apps: []string{"jobs/billing", " admin "}, want: []string{"app-prod-admin", "app-prod-billing"}},
+		{orgs: []string{"dev"}, apps: []string{"store"}, want: []string{"app-dev-store", "app-prod-store"}},
+		{orgs: []string{"missing"}, spaces: []string{"missing/web"}, want: []string{}},
+	} {
+		ids, err := c.ResolveSourceIDs(tc.orgs, tc.spaces, tc.apps)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.want, ids, "orgs %v, spaces %v, apps %v", tc.orgs, tc.spaces, tc.apps)
+	}
+}
+
+func TestResolveSourceIDsErrors(t *testing.T) {
+	c := newTestManager(t)
+	_, err := c.ResolveSourceIDs(nil, []string{"a/b/c"}, nil)
+	assert.EqualError(t, err, `invalid space "a/b/c", expected space or org/space`)
+
+	c.client.Config.ApiAddress += "/unknown"
+	_, err = c.ResolveSourceIDs([]string{"prod"}, nil, nil)
+	assert.Error(t, err)
+}
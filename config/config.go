@@ -15,6 +15,18 @@ import (
 
 const envPrefix = "NRF"
 
+// Required environment variables
+var required = []string{
+	"CF_API_URL",
+	"CF_API_UAA_URL",
+	"CF_CLIENT_ID",
+	"CF_CLIENT_SECRET",
+	"CF_API_USERNAME",
+	"CF_API_PASSWORD",
+	"NEWRELIC_INSERT_KEY",
+	"NEWRELIC_ACCOUNT_ID",
+}
+
 var instance *Config
 var once = &sync.Once{}
 
@@ -38,28 +50,16 @@ func set() *Config {
 	v.SetEnvPrefix(envPrefix)
 	v.AutomaticEnv()
 
-	// Required environment variables
-	for _, s := range []string{
-		"CF_API_URL",
-		"CF_API_UAA_URL",
-		"CF_CLIENT_ID",
-		"CF_CLIENT_SECRET",
-		"CF_API_USERNAME",
-		"CF_API_PASSWORD",
-		"NEWRELIC_INSERT_KEY",
-		"NEWRELIC_ACCOUNT_ID",
-	} {
-		if v.GetString(s) == "" {
-			logrus.Fatalf("missing required env variable %s_%s", envPrefix, s)
-		} else {
-			v.BindEnv(s)
-		}
+	for _, s := range required {
+		v.BindEnv(s)
 	}
 
 	v.BindEnv(EnvCFAPIRUL)
 	v.BindEnv("CF_API_UAA_URL")
 	rlpgURL := strings.Replace(v.GetString("CF_API_UAA_URL"), "uaa", "log-stream", 1)
 	v.SetDefault("CF_API_RLPG_URL", rlpgURL)
+	logCacheURL := strings.Replace(v.GetString("CF_API_UAA_URL"), "uaa", "log-cache", 1)
+	v.SetDefault("CF_API_LOG_CACHE_URL", logCacheURL)
 	v.BindEnv("CF_API_CLIENT_ID")
 	v.BindEnv("CF_API_CLIENT_SECRET")
 	// Passing in two strings to override config prefix.
@@ -72,6 +72,11 @@ func set() *Config {
 	// Only set NRF_CF_SKIP_SSL=true in development environments with self-signed certificates.
 	// Disabling SSL verification exposes the application to man-in-the-middle attacks.
 	v.SetDefault("CF_SKIP_SSL", false)
+	// CA bundle trusted for the CF API, UAA and RLP Gateway, and an optional client
+	// certificate and key for mutual TLS - inline PEM or file paths.
+	v.SetDefault("CF_CA_CERT", "")
+	v.SetDefault("CF_CLIENT_CERT", "")
+	v.SetDefault("CF_CLIENT_KEY", "")
 
 	v.SetDefault("HEALTH_PORT", 8080)
 
@@ -95,8 +100,59 @@ func set() *Config {
 
 	v.SetDefault(EnvFirehoseID, "newrelic-firehose")
 	v.SetDefault("FIREHOSE_DIODE_BUFFER", 8192)
+	// TCP port receiving CF syslog drains, 0 disables the syslog listener
+	v.SetDefault("SYSLOG_PORT", 0)
+	// PEM certificate and key, inline or file paths, enabling TLS for the syslog listener
+	v.SetDefault("SYSLOG_TLS_CERT", "")
+	v.SetDefault("SYSLOG_TLS_KEY", "")
+	// Backfill envelopes missed while the firehose reconnects from the CF Log Cache. Off by
+	// default, envelopes other instances with the same FIREHOSE_ID received are read too.
+	v.SetDefault("FIREHOSE_BACKFILL", false)
+	// Longest gap in seconds read from the Log Cache after a reconnect
+	v.SetDefault("FIREHOSE_BACKFILL_MAX_SECS", 300)
+	// Envelopes per Log Cache request, 1000 is the Log Cache maximum
+	v.SetDefault("FIREHOSE_BACKFILL_LIMIT", 1000)
+	// Most recently seen sources read from the Log Cache after a reconnect, 0 reads all
+	v.SetDefault("FIREHOSE_BACKFILL_MAX_SOURCES", 500)
+	// Concurrent Log Cache requests of a backfill
+	v.SetDefault("FIREHOSE_BACKFILL_CONCURRENCY", 4)
+	// Write raw envelope batches to this file for offline replay, empty disables recording
+	v.SetDefault("FIREHOSE_RECORD_FILE", "")
+	// Number of router workers, 0 starts one worker per CPU
+	v.SetDefault("ROUTER_WORKERS", 0)
+	// Envelopes each router worker can hold before the router blocks
+	v.SetDefault("ROUTER_WORKER_BUFFER", 1024)
 	v.SetDefault("FIREHOSE_HTTP_TIMEOUT_MINS", 20)
 	v.SetDefault("FIREHOSE_RESTART_THRESH_SECS", 15)
+	// Directory for envelopes the diode can't hold, disabled when empty
+	v.SetDefault("FIREHOSE_SPILL_DIR", "")
+	// Half the 256M app disk_quota, the spill queue shares the disk with the droplet and
+	// must stay below the quota
+	v.SetDefault("FIREHOSE_SPILL_MAX_MB", 128)
+	v.SetDefault("FIREHOSE_SPILL_MAX_AGE_SECS", 300)
+	// Seconds the router gets to read the spilled envelopes when the nozzle closes
+	v.SetDefault("FIREHOSE_SPILL_DRAIN_SECS", 30)
+	// Jittered exponential backoff between failed RLP Gateway connection attempts
+	v.SetDefault("FIREHOSE_BACKOFF_INITIAL_MS", 500)
+	v.SetDefault("FIREHOSE_BACKOFF_MAX_SECS", 60)
+	// Seconds without a streaming RLP Gateway connection before the health check fails
+	v.SetDefault("FIREHOSE_UNHEALTHY_SECS", 300)
+	// Limit the subscription to the apps in these orgs and spaces, or to these apps - , or | separated.
+	// Spaces can be set as org/space and apps as space/app or org/space/app.
+	v.SetDefault("FIREHOSE_SOURCE_ORGS", "")
+	v.SetDefault("FIREHOSE_SOURCE_SPACES", "")
+	v.SetDefault("FIREHOSE_SOURCE_APPS", "")
+	// Additional source IDs, for example gorouter, included in a scoped subscription
+	v.SetDefault("FIREHOSE_SOURCE_IDS", "")
+	// Interval in seconds between resolving the scoped org, space and app names
+	v.SetDefault("FIREHOSE_SOURCE_REFRESH_SECS", 300)
+	// Source IDs selected by one RLP Gateway stream, more exceed request URL length limits.
+	// Larger subscriptions are split across the FIREHOSE_STREAM_COUNT streams.
+	v.SetDefault("FIREHOSE_SOURCE_IDS_PER_STREAM", 25)
+	// Consume envelopes from the RLP Gateway, disable to only receive syslog drains
+	v.SetDefault("FIREHOSE_RLP_ENABLED", true)
+	// Number of concurrent RLP Gateway streams sharing the FIREHOSE_ID subscription
+	v.SetDefault("FIREHOSE_STREAM_COUNT", 1)
 	v.SetDefault("NEWRELIC_DRAIN_INTERVAL", "59s")
 	v.SetDefault("NEWRELIC_ENQUEUE_TIMEOUT", "1s")
 
@@ -105,6 +161,11 @@ func set() *Config {
 	v.SetDefault(NewRelicEventTypeCounterEvent, "PCFCounterEvent")
 	v.SetDefault(NewRelicEventTypeLogMessage, "PCFLogMessage")
 	v.SetDefault(NewRelicEventTypeHTTPStartStop, "PCFHttpStartStop")
+	v.SetDefault(NewRelicEventTypeEvent, "PCFEvent")
+	v.SetDefault(NewRelicEventTypeHTTPLatency, "PCFHttpLatency")
+	v.SetDefault(NewRelicEventTypeLogDropped, "PCFLogMessageDropped")
+	v.SetDefault(NewRelicEventTypeRedaction, "PCFRedaction")
+	v.SetDefault(NewRelicEventTypeCardinality, "PCFCardinalityOverflow")
 
 	v.SetDefault("ATTR_PREFIX", "pcf")
 	v.SetDefault(EnvEnvelopeType, "envelope.type")
@@ -126,6 +187,56 @@ func set() *Config {
 	v.SetDefault(EnvAppRpmId, "app.rpm.id")
 	v.SetDefault(EnvAppInsertKey, "app.insert.key")
 
+	// Accumulator instances, kind or kind:name - , or | separated. See the registry package.
+	v.SetDefault("ACCUMULATORS", "counter|container|value|logmessage|http|event")
+	// Accumulator instance names to leave out of ACCUMULATORS
+	v.SetDefault("ACCUMULATORS_DISABLE", "")
+
+	// ValueMetric and CounterEvent filters: name, origin, job or deployment glob or /regexp/ rules,
+	// see accumulators.MetricFilter.
+	v.SetDefault("VALUEMETRIC_INCLUDE", "")
+	v.SetDefault("VALUEMETRIC_EXCLUDE", "")
+	v.SetDefault("COUNTEREVENT_INCLUDE", "")
+	v.SetDefault("COUNTEREVENT_EXCLUDE", "")
+
+	// Flatten JSON log messages into LOGMESSAGE_JSON_PREFIX attributes, objects nested deeper than
+	// LOGMESSAGE_JSON_MAX_DEPTH are kept as JSON. Include and exclude keys are , or | separated globs.
+	v.SetDefault("LOGMESSAGE_JSON", false)
+	v.SetDefault("LOGMESSAGE_JSON_PREFIX", "log.json.")
+	v.SetDefault("LOGMESSAGE_JSON_MAX_DEPTH", 3)
+	v.SetDefault("LOGMESSAGE_JSON_MAX_ATTRIBUTES", 64)
+	v.SetDefault("LOGMESSAGE_JSON_INCLUDE_KEYS", "")
+	v.SetDefault("LOGMESSAGE_JSON_EXCLUDE_KEYS", "")
+
+	// Parse Gorouter (RTR) access logs into rtr.* attributes, only sending the parsed
+	// attributes, without the message, when LOGMESSAGE_GOROUTER_PARSED_ONLY is set.
+	v.SetDefault("LOGMESSAGE_GOROUTER", false)
+	v.SetDefault("LOGMESSAGE_GOROUTER_PARSED_ONLY", false)
+
+	// Stitch multi-line log messages, such as stack traces, into a single log message. A line
+	// starts a new record when it matches LOGMESSAGE_MULTILINE_START and not
+	// LOGMESSAGE_MULTILINE_CONTINUE, a record is sent when it has not grown for the timeout.
+	v.SetDefault("LOGMESSAGE_MULTILINE", false)
+	v.SetDefault("LOGMESSAGE_MULTILINE_START", `^\S`)
+	v.SetDefault("LOGMESSAGE_MULTILINE_CONTINUE", `^(Caused by:|\.\.\. \d+ (more|common frames omitted))`)
+	v.SetDefault("LOGMESSAGE_MULTILINE_TIMEOUT_MS", 1000)
+	v.SetDefault("LOGMESSAGE_MULTILINE_MAX_LINES", 500)
+
+	// Log messages too long for the Event API are truncated, sent in chunks sharing a
+	// log.message.id, or sent to New Relic Logs: truncate, chunk or logs.
+	v.SetDefault("LOGMESSAGE_OVERSIZE", "truncate")
+
+	// Sample log messages, keeping LOGMESSAGE_SAMPLE_RATE of them, and limit the log messages
+	// per second of each app and space, 0 is unlimited. Bursts default to a second of log messages.
+	v.SetDefault("LOGMESSAGE_SAMPLE_RATE", 1)
+	v.SetDefault("LOGMESSAGE_APP_RATE", 0)
+	v.SetDefault("LOGMESSAGE_APP_BURST", 0)
+	v.SetDefault("LOGMESSAGE_SPACE_RATE", 0)
+	v.SetDefault("LOGMESSAGE_SPACE_BURST", 0)
+
+	// Log message filter expression, see logmessage.Filter. The source and message lists below
+	// are still honoured, a log message must pass both.
+	v.SetDefault("LOGMESSAGE_FILTER", "")
 	// Filtering capabilities for log message events - , or | separated values
 	v.SetDefault("LOGMESSAGE_SOURCE_INCLUDE", "")
 	v.SetDefault("LOGMESSAGE_SOURCE_EXCLUDE", "")
@@ -134,7 +245,7 @@ func set() *Config {
 
 	// Filtering capabilities for envelope types - | separated values.
 	// By default, all message types are enabled.  User configurations will override this behavior.
-	v.SetDefault("ENABLED_ENVELOPE_TYPES", "ContainerMetric|CounterEvent|HttpStartStop|LogMessage|ValueMetric")
+	v.SetDefault("ENABLED_ENVELOPE_TYPES", "ContainerMetric|CounterEvent|Event|HttpStartStop|LogMessage|ValueMetric")
 
 	// Default account location will be US unless set to EU by cf push or tile.
 	v.SetDefault("NEWRELIC_ACCOUNT_REGION", "US")
@@ -144,10 +255,58 @@ func set() *Config {
 	v.SetDefault("LOGS_LOGMESSAGE", false)
 	v.SetDefault("LOGS_HTTP", false)
 
+	// Aggregate HttpStartStop timers into latency metrics sent at each harvest
+	// instead of sending an event per request.
+	v.SetDefault("HTTP_AGGREGATE", false)
+
+	// Limit the entities and metrics each accumulator holds between harvests, in total and
+	// per envelope source, 0 is unlimited. Samples over the limits are accumulated in the
+	// __overflow__ entity and the sources tripping a limit are reported.
+	v.SetDefault("CARDINALITY_MAX_ENTITIES", 0)
+	v.SetDefault("CARDINALITY_MAX_METRICS", 0)
+	v.SetDefault("CARDINALITY_MAX_SOURCE_ENTITIES", 0)
+	v.SetDefault("CARDINALITY_MAX_SOURCE_METRICS", 0)
+
+	// Rename, drop, copy, hash, add and convert the attributes of every event and log
+	// entry before it is sent, see transform.Pipeline. Hashes are keyed by REDACT_HASH_KEY.
+	v.SetDefault("TRANSFORM_RULES", "")
+
+	// Redact log messages and HttpStartStop URIs and user agents, see accumulators.Redactor.
+	// REDACT_DETECTORS enables built-in detectors: email, card, bearer, password, aws_key
+	// and url_password. Hashed matches are keyed by REDACT_HASH_KEY, required by hash rules.
+	v.SetDefault("REDACT_DETECTORS", "")
+	v.SetDefault("REDACT_RULES", "")
+	v.SetDefault("REDACT_HASH_KEY", "")
+
+	// Record container CPU and ValueMetric gauges as Distribution metrics, emitting
+	// the METRICS_PERCENTILES of the samples of each harvest.
+	v.SetDefault("METRICS_DISTRIBUTION", false)
+	v.SetDefault("METRICS_PERCENTILES", "50|90|95|99")
+
+	// Counter totals more than COUNTER_GAP_SECS apart are a gap, the source was gone
+	// and no rate is derived across it.
+	v.SetDefault("COUNTER_GAP_SECS", 300)
+
 	config := &Config{v}
 	return config
 }
 
+// Validate exits when a required environment variable is missing or the TLS settings are invalid. Replay runs
+// without a foundation and skips it.
+func (c *Config) Validate() {
+	for _, s := range required {
+		if c.GetString(s) == "" {
+			logrus.Fatalf("missing required env variable %s_%s", envPrefix, s)
+		}
+	}
+	if _, err := c.GetTLSConfig(); err != nil {
+		logrus.Fatalf("invalid TLS configuration: %s", err.Error())
+	}
+	if _, err := c.GetPercentiles(); err != nil {
+		logrus.Fatalf("invalid percentiles: %s", err.Error())
+	}
+}
+
 // GetNewRelicConfig ...
 func (c *Config) GetNewRelicConfig() (key string, id string, region string) {
 	key = c.GetString("NEWRELIC_INSERT_KEY")
@@ -180,6 +339,29 @@ func (c *Config) GetSelectors() []*loggregator_v2.Selector {
 	if strings.Contains(e, "logmessage") {
 		s = append(s, &loggregator_v2.Selector{Message: &loggregator_v2.Selector_Log{Log: &loggregator_v2.LogSelector{}}})
 	}
+	// CounterEvent also contains event, so Event is matched as a whole type name.
+	for _, t := range strings.FieldsFunc(e, func(r rune) bool { return r == '|' || r == ',' }) {
+		if t == "event" {
+			s = append(s, &loggregator_v2.Selector{Message: &loggregator_v2.Selector_Event{Event: &loggregator_v2.EventSelector{}}})
+			break
+		}
+	}
+	return s
+}
+
+// GetSourceSelectors returns the enabled envelope type selectors for each source ID.
+// Without source IDs the selectors subscribe to the whole foundation.
+func (c *Config) GetSourceSelectors(sourceIDs []string) []*loggregator_v2.Selector {
+	types := c.GetSelectors()
+	if len(sourceIDs) == 0 {
+		return types
+	}
+	s := make([]*loggregator_v2.Selector, 0, len(types)*len(sourceIDs))
+	for _, id := range sourceIDs {
+		for _, t := range types {
+			s = append(s, &loggregator_v2.Selector{SourceId: id, Message: t.Message})
+		}
+	}
 	return s
 }
 
@@ -0,0 +1,41 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"testing"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// selected envelope types of the selectors.
+func selected(selectors []*loggregator_v2.Selector) (types []string) {
+	for _, s := range selectors {
+		types = append(types, fmt.Sprintf("%T", s.Message))
+	}
+	return types
+}
+
+func TestGetSelectors(t *testing.T) {
+	c := Get().Scoped("selectors")
+	for _, test := range []struct {
+		enabled string
+		types   []string
+	}{
+		// CounterEvent contains event, Event envelopes are only selected by name.
+		{"CounterEvent", []string{"*loggregator_v2.Selector_Counter"}},
+		{"Event", []string{"*loggregator_v2.Selector_Event"}},
+		{"CounterEvent, Event", []string{"*loggregator_v2.Selector_Counter", "*loggregator_v2.Selector_Event"}},
+		{"ValueMetric|ContainerMetric|HttpStartStop|LogMessage", []string{
+			"*loggregator_v2.Selector_Gauge",
+			"*loggregator_v2.Selector_Timer",
+			"*loggregator_v2.Selector_Log",
+		}},
+	} {
+		c.Set("ENABLED_ENVELOPE_TYPES", test.enabled)
+		assert.Equal(t, test.types, selected(c.GetSelectors()), test.enabled)
+	}
+}
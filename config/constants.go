@@ -31,4 +31,9 @@ const (
 	NewRelicEventTypeCounterEvent  = "NEWRELIC_EVENT_TYPE_COUNTER"
 	NewRelicEventTypeLogMessage    = "NEWRELIC_EVENT_TYPE_LOG"
 	NewRelicEventTypeHTTPStartStop = "NEWRELIC_EVENT_TYPE_HTTPSTARTSTOP"
+	NewRelicEventTypeEvent         = "NEWRELIC_EVENT_TYPE_EVENT"
+	NewRelicEventTypeHTTPLatency   = "NEWRELIC_EVENT_TYPE_HTTPLATENCY"
+	NewRelicEventTypeLogDropped    = "NEWRELIC_EVENT_TYPE_LOG_DROPPED"
+	NewRelicEventTypeRedaction     = "NEWRELIC_EVENT_TYPE_REDACTION"
+	NewRelicEventTypeCardinality   = "NEWRELIC_EVENT_TYPE_CARDINALITY"
 )
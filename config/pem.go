@@ -0,0 +1,22 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+// GetPEM returns PEM data configured either inline or as a path to a PEM file.
+// It returns nil when the setting is empty.
+func (c *Config) GetPEM(name string) ([]byte, error) {
+	v := strings.TrimSpace(c.GetString(name))
+	if v == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(v, "-----BEGIN") {
+		return []byte(v), nil
+	}
+	return ioutil.ReadFile(v)
+}
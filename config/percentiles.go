@@ -0,0 +1,23 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GetPercentiles emitted for Distribution metrics, | or , separated values between 0 and 100.
+func (c *Config) GetPercentiles() ([]float64, error) {
+	var percentiles []float64
+	for _, s := range c.GetFilter("METRICS_PERCENTILES") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("NRF_METRICS_PERCENTILES: %q is not a percentile between 0 and 100", s)
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
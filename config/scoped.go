@@ -0,0 +1,35 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// scopePrefix of the environment variables of a named accumulator instance.
+const scopePrefix = "ACC_"
+
+// Scoped returns a copy of the configuration where NRF_ACC_<NAME>_<SETTING> environment
+// variables override <SETTING>, so several instances of an accumulator can run with
+// different settings, e.g. NRF_ACC_AUDIT_LOGMESSAGE_SOURCE_INCLUDE for the audit instance.
+// The ACC_ infix keeps instance names from matching other settings, an instance named
+// router would otherwise read NRF_ROUTER_WORKERS as its WORKERS setting.
+func (c *Config) Scoped(name string) *Config {
+	v := viper.New()
+	for _, k := range c.AllKeys() {
+		v.Set(k, c.Get(k))
+	}
+
+	prefix := envPrefix + "_" + scopePrefix + strings.ToUpper(name) + "_"
+	for _, env := range os.Environ() {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) == 2 && strings.HasPrefix(kv[0], prefix) {
+			v.Set(strings.TrimPrefix(kv[0], prefix), kv[1])
+		}
+	}
+	return &Config{v}
+}
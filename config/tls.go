@@ -0,0 +1,56 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// GetTLSConfig returns the TLS configuration shared by the CF API, UAA and RLP Gateway
+// clients. CF_CA_CERT is trusted in addition to the system roots, and CF_CLIENT_CERT and
+// CF_CLIENT_KEY are presented for mutual TLS. All three accept inline PEM or a file path.
+func (c *Config) GetTLSConfig() (*tls.Config, error) {
+	t := &tls.Config{
+		// SECURITY: InsecureSkipVerify should remain false in production
+		// to prevent man-in-the-middle attacks
+		InsecureSkipVerify: c.GetBool("CF_SKIP_SSL"),
+	}
+
+	ca, err := c.GetPEM("CF_CA_CERT")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s_CF_CA_CERT: %s", envPrefix, err.Error())
+	}
+	if ca != nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("%s_CF_CA_CERT does not contain a valid PEM certificate", envPrefix)
+		}
+		t.RootCAs = pool
+	}
+
+	cert, err := c.GetPEM("CF_CLIENT_CERT")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s_CF_CLIENT_CERT: %s", envPrefix, err.Error())
+	}
+	key, err := c.GetPEM("CF_CLIENT_KEY")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s_CF_CLIENT_KEY: %s", envPrefix, err.Error())
+	}
+	if (cert == nil) != (key == nil) {
+		return nil, fmt.Errorf("%s_CF_CLIENT_CERT and %s_CF_CLIENT_KEY must be set together", envPrefix, envPrefix)
+	}
+	if cert != nil {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s_CF_CLIENT_CERT and %s_CF_CLIENT_KEY: %s", envPrefix, envPrefix, err.Error())
+		}
+		t.Certificates = []tls.Certificate{pair}
+	}
+	return t, nil
+}
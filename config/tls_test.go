@@ -0,0 +1,87 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCertificate returns a self-signed PEM certificate and its PEM key.
+func testCertificate(t *testing.T, name string) (cert string, key string) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &k.PublicKey, k)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(k)
+	assert.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestGetTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+	ca, _ := testCertificate(t, "ca")
+	cert, key := testCertificate(t, "client")
+	_, otherKey := testCertificate(t, "other")
+
+	for _, test := range []struct {
+		name   string
+		caCert string
+		cert   string
+		key    string
+		err    string
+		roots  bool
+		certs  int
+	}{
+		{name: "none"},
+		{name: "inline", caCert: ca, cert: cert, key: key, roots: true, certs: 1},
+		{name: "files", caCert: write("ca.pem", ca), cert: write("cert.pem", cert), key: write("key.pem", key), roots: true, certs: 1},
+		{name: "missing file", caCert: filepath.Join(dir, "missing.pem"), err: "failed to read NRF_CF_CA_CERT"},
+		{name: "invalid CA", caCert: "-----BEGIN CERTIFICATE-----\nnot a certificate\n-----END CERTIFICATE-----", err: "NRF_CF_CA_CERT does not contain a valid PEM certificate"},
+		{name: "cert without key", cert: cert, err: "must be set together"},
+		{name: "key without cert", key: key, err: "must be set together"},
+		{name: "bad keypair", cert: cert, key: otherKey, err: "invalid NRF_CF_CLIENT_CERT and NRF_CF_CLIENT_KEY"},
+	} {
+		c := Get().Scoped("tls")
+		c.Set("CF_CA_CERT", test.caCert)
+		c.Set("CF_CLIENT_CERT", test.cert)
+		c.Set("CF_CLIENT_KEY", test.key)
+		tlsConfig, err := c.GetTLSConfig()
+		if test.err != "" {
+			if assert.Error(t, err, test.name) {
+				assert.Contains(t, err.Error(), test.err, test.name)
+			}
+			continue
+		}
+		if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.roots, tlsConfig.RootCAs != nil, test.name)
+			assert.Len(t, tlsConfig.Certificates, test.certs, test.name)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package firehose
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/cloudfoundry/go-loggregator"
+	"github.com/golang/protobuf/jsonpb"
+)
+
+// Backfiller tracks the last envelope each source sent to the streams of this nozzle
+// instance and, after a stream reconnects, reads what each source emitted since from the
+// CF Log Cache, for at most FIREHOSE_BACKFILL_MAX_SECS. Sources still streaming, such as
+// the sources of the other streams of this instance, have nothing to backfill.
+//
+// Envelopes of the outage window are deduplicated on both sides: the backfill skips the
+// ones the streams already routed, and the streams skip the ones the backfill routed.
+//
+// The Log Cache returns every envelope of a source, including those the RLP Gateway
+// delivered to other nozzle instances sharing the FIREHOSE_ID during the outage, and
+// those are counted twice. Backfill is disabled by default for this reason, it suits
+// deployments of a single nozzle instance.
+type Backfiller struct {
+	firehose    *Firehose
+	doer        loggregator.Doer
+	url         string
+	types       []string
+	maxGap      time.Duration
+	limit       int
+	maxSources  int
+	concurrency int
+	running     int32
+	latest      map[string]int64
+	pruned      int64
+	until       int64
+	done        bool
+	seen        map[uint64]struct{}
+	sync        *sync.Mutex
+}
+
+// dedupGrace the streams keep deduplicating after a backfill, for envelopes of the
+// outage window that arrive late.
+const dedupGrace = 10 * time.Second
+
+// NewBackfiller ...
+func NewBackfiller(f *Firehose, doer loggregator.Doer) *Backfiller {
+	b := &Backfiller{
+		firehose:    f,
+		doer:        doer,
+		url:         f.config.GetString("CF_API_LOG_CACHE_URL"),
+		maxGap:      time.Duration(f.config.GetInt("FIREHOSE_BACKFILL_MAX_SECS")) * time.Second,
+		limit:       f.config.GetInt("FIREHOSE_BACKFILL_LIMIT"),
+		maxSources:  f.config.GetInt("FIREHOSE_BACKFILL_MAX_SOURCES"),
+		concurrency: f.config.GetInt("FIREHOSE_BACKFILL_CONCURRENCY"),
+		latest:      map[string]int64{},
+		sync:        &sync.Mutex{},
+	}
+	if b.limit < 1 || b.limit > 1000 {
+		b.limit = 1000
+	}
+	if b.concurrency < 1 {
+		b.concurrency = 1
+	}
+	// Log Cache only backfills the metric, log and event envelope types.
+	for _, s := range f.config.GetSelectors() {
+		switch s.Message.(type) {
+		case *loggregator_v2.Selector_Gauge:
+			b.types = append(b.types, "GAUGE")
+		case *loggregator_v2.Selector_Counter:
+			b.types = append(b.types, "COUNTER")
+		case *loggregator_v2.Selector_Log:
+			b.types = append(b.types, "LOG")
+		case *loggregator_v2.Selector_Event:
+			b.types = append(b.types, "EVENT")
+		}
+	}
+	return b
+}
+
+// Track records an envelope received from the RLP Gateway, returning true when the
+// backfill already routed it.
+func (b *Backfiller) Track(e *loggregator_v2.Envelope) (duplicate bool) {
+	ts := e.GetTimestamp()
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	if ts > b.latest[e.GetSourceId()] {
+		b.latest[e.GetSourceId()] = ts
+	}
+	// Sources quiet for longer than the backfill window are forgotten.
+	if ts-b.pruned > b.maxGap.Nanoseconds() {
+		b.prune(ts - b.maxGap.Nanoseconds())
+		b.pruned = ts
+	}
+	if b.seen == nil {
+		return false
+	}
+	if b.done && ts > b.until+dedupGrace.Nanoseconds() {
+		b.until, b.seen = 0, nil
+		return false
+	}
+	if ts > b.until {
+		return false
+	}
+	fp := fingerprint(e)
+	if _, duplicate = b.seen[fp]; !duplicate {
+		b.seen[fp] = struct{}{}
+	}
+	return duplicate
+}
+
+// prune the sources last seen before the timestamp. Callers hold sync.
+func (b *Backfiller) prune(before int64) {
+	for source, last := range b.latest {
+		if last < before {
+			delete(b.latest, source)
+		}
+	}
+}
+
+// Reconnected starts a backfill of the outage of this instance's streams, unless one is
+// already running. Streams call it before they connect again.
+func (b *Backfiller) Reconnected() {
+	if !atomic.CompareAndSwapInt32(&b.running, 0, 1) {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	b.sync.Lock()
+	b.prune(now - 2*b.maxGap.Nanoseconds())
+	sources := b.sources(now)
+	if len(sources) == 0 {
+		b.sync.Unlock()
+		atomic.StoreInt32(&b.running, 0)
+		return
+	}
+	b.until, b.done = now, false
+	b.seen = map[uint64]struct{}{}
+	b.sync.Unlock()
+
+	if b.maxSources > 0 && len(sources) > b.maxSources {
+		b.firehose.log.Warnf(
+			"Log Cache backfill limited to the %d most recent of %d sources, see FIREHOSE_BACKFILL_MAX_SOURCES",
+			b.maxSources, len(sources),
+		)
+		sources = sources[:b.maxSources]
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&b.running, 0)
+		count := b.backfill(sources, now)
+		b.sync.Lock()
+		b.done = true
+		b.sync.Unlock()
+		b.firehose.log.Infof(
+			"Log Cache backfilled %d envelopes from %d sources for up to %v",
+			count, len(sources), time.Duration(now-sources[len(sources)-1].start).Round(time.Second),
+		)
+	}()
+}
+
+// backfillSource is read from start, after the last envelope the source sent.
+type backfillSource struct {
+	id    string
+	start int64
+}
+
+// sources to backfill until now, the most recently seen first. The outage of a source
+// starts after the last envelope it sent, and is read for at most the backfill window.
+// Sources seen within the last second are still streaming. Callers hold sync.
+func (b *Backfiller) sources(now int64) []backfillSource {
+	oldest := now - b.maxGap.Nanoseconds()
+	sources := make([]backfillSource, 0, len(b.latest))
+	for id, last := range b.latest {
+		start := last + 1
+		if start < oldest {
+			start = oldest
+		}
+		if now-start < time.Second.Nanoseconds() {
+			continue
+		}
+		sources = append(sources, backfillSource{id: id, start: start})
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].start != sources[j].start {
+			return sources[i].start > sources[j].start
+		}
+		return sources[i].id < sources[j].id
+	})
+	return sources
+}
+
+// backfill reads the sources until end with up to FIREHOSE_BACKFILL_CONCURRENCY concurrent
+// requests, returning the number of envelopes routed.
+func (b *Backfiller) backfill(sources []backfillSource, end int64) int {
+	var count int64
+	work := make(chan backfillSource)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < b.concurrency && i < len(sources); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for source := range work {
+				n, err := b.read(source.id, source.start, end)
+				if err != nil {
+					b.firehose.log.Warnf("Log Cache backfill failed for %s: %s", source.id, err.Error())
+				}
+				atomic.AddInt64(&count, int64(n))
+			}
+		}()
+	}
+	for _, source := range sources {
+		work <- source
+	}
+	close(work)
+	wg.Wait()
+	return int(count)
+}
+
+// read pages through the Log Cache for a source between start and end (exclusive)
+// and sets envelopes that have not already been seen on the Firehose queue.
+func (b *Backfiller) read(source string, start int64, end int64) (count int, err error) {
+	for start < end {
+		batch, err := b.request(source, start, end)
+		if err != nil {
+			return count, err
+		}
+		for _, e := range batch {
+			if e.GetTimestamp() >= start {
+				start = e.GetTimestamp() + 1
+			}
+			fp := fingerprint(e)
+			b.sync.Lock()
+			_, dup := b.seen[fp]
+			if b.seen != nil {
+				b.seen[fp] = struct{}{}
+			}
+			b.sync.Unlock()
+			if dup {
+				continue
+			}
+			b.firehose.Queue.Set(e)
+			count++
+		}
+		if len(batch) == 0 || len(batch) < b.limit {
+			return count, nil
+		}
+	}
+	return count, nil
+}
+
+func (b *Backfiller) request(source string, start int64, end int64) ([]*loggregator_v2.Envelope, error) {
+	q := url.Values{}
+	q.Set("start_time", strconv.FormatInt(start, 10))
+	q.Set("end_time", strconv.FormatInt(end, 10))
+	q.Set("limit", strconv.Itoa(b.limit))
+	for _, t := range b.types {
+		q.Add("envelope_types", t)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/v1/read/%s?%s", b.url, url.PathEscape(source), q.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.doer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+	}
+
+	var r struct {
+		Envelopes json.RawMessage `json:"envelopes"`
+	}
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, err
+	}
+	if len(r.Envelopes) == 0 {
+		return nil, nil
+	}
+	var eb loggregator_v2.EnvelopeBatch
+	u := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err := u.Unmarshal(bytes.NewReader(r.Envelopes), &eb); err != nil {
+		return nil, err
+	}
+	return eb.GetBatch(), nil
+}
+
+// fingerprint identifies an envelope by its source, timestamp and content.
+func fingerprint(e *loggregator_v2.Envelope) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%s|%d|", e.GetSourceId(), e.GetInstanceId(), e.GetTimestamp())
+	switch m := e.Message.(type) {
+	case *loggregator_v2.Envelope_Log:
+		h.Write(m.Log.GetPayload())
+	case *loggregator_v2.Envelope_Counter:
+		fmt.Fprintf(h, "counter|%s|%d", m.Counter.GetName(), m.Counter.GetTotal())
+	case *loggregator_v2.Envelope_Gauge:
+		names := make([]string, 0, len(m.Gauge.GetMetrics()))
+		for name := range m.Gauge.GetMetrics() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(h, "gauge|%v", names)
+	case *loggregator_v2.Envelope_Timer:
+		fmt.Fprintf(h, "timer|%s|%d", m.Timer.GetName(), m.Timer.GetStart())
+	case *loggregator_v2.Envelope_Event:
+		fmt.Fprintf(h, "event|%s", m.Event.GetTitle())
+	}
+	return h.Sum64()
+}
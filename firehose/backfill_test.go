@@ -0,0 +1,220 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package firehose
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/go-diodes"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// logCache answers Log Cache reads with the envelopes of each source, recording the requests.
+type logCache struct {
+	envelopes map[string][]*loggregator_v2.Envelope
+	errors    map[string]error
+	onRead    func(source string)
+	requests  []*http.Request
+	lock      sync.Mutex
+}
+
+func (l *logCache) Do(r *http.Request) (*http.Response, error) {
+	source := strings.TrimPrefix(r.URL.Path, "/api/v1/read/")
+	l.lock.Lock()
+	l.requests = append(l.requests, r)
+	l.lock.Unlock()
+	if l.onRead != nil {
+		l.onRead(source)
+	}
+	if err := l.errors[source]; err != nil {
+		return nil, err
+	}
+	if source == "unavailable" {
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: ioutil.NopCloser(strings.NewReader("down"))}, nil
+	}
+	body := &bytes.Buffer{}
+	body.WriteString(`{"envelopes":`)
+	(&jsonpb.Marshaler{}).Marshal(body, &loggregator_v2.EnvelopeBatch{Batch: l.envelopes[source]})
+	body.WriteString(`}`)
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(body)}, nil
+}
+
+func (l *logCache) sources() map[string]*http.Request {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	sources := map[string]*http.Request{}
+	for _, r := range l.requests {
+		sources[strings.TrimPrefix(r.URL.Path, "/api/v1/read/")] = r
+	}
+	return sources
+}
+
+func newTestBackfiller(l *logCache) *Backfiller {
+	c := config.Get()
+	f := &Firehose{
+		log:    logger.New(c),
+		config: c,
+		Queue:  NewOneToOneEnvelope(100, diodes.AlertFunc(func(int) {})),
+	}
+	return NewBackfiller(f, l)
+}
+
+// backfill runs a backfill and returns the envelopes it routed.
+func backfill(t *testing.T, b *Backfiller) (routed []*loggregator_v2.Envelope) {
+	b.Reconnected()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&b.running) == 0 }, time.Second, time.Millisecond)
+	for {
+		e, ok := b.firehose.Queue.TryNext()
+		if !ok {
+			return routed
+		}
+		routed = append(routed, e)
+	}
+}
+
+func logEnvelope(source string, ts int64, payload string) *loggregator_v2.Envelope {
+	return &loggregator_v2.Envelope{
+		SourceId:  source,
+		Timestamp: ts,
+		Message:   &loggregator_v2.Envelope_Log{Log: &loggregator_v2.Log{Payload: []byte(payload)}},
+	}
+}
+
+func TestBackfillReadsTheOutageWindow(t *testing.T) {
+	l := &logCache{}
+	b := newTestBackfiller(l)
+	now := time.Now().UnixNano()
+	b.Track(logEnvelope("a", now-int64(30*time.Second), "a"))
+	b.Track(logEnvelope("b", now-int64(20*time.Second), "b"))
+	// A source of a stream that kept streaming is not read.
+	b.Track(logEnvelope("c", now, "c"))
+
+	backfill(t, b)
+	sources := l.sources()
+	assert.Len(t, sources, 2)
+	// The outage of each source started after the last envelope it sent.
+	for source, last := range map[string]int64{"a": now - int64(30*time.Second), "b": now - int64(20*time.Second)} {
+		if r, ok := sources[source]; assert.True(t, ok, source) {
+			start, _ := strconv.ParseInt(r.URL.Query().Get("start_time"), 10, 64)
+			end, _ := strconv.ParseInt(r.URL.Query().Get("end_time"), 10, 64)
+			assert.Equal(t, last+1, start)
+			assert.True(t, end >= now, end)
+		}
+	}
+}
+
+func TestBackfillWindowIsCapped(t *testing.T) {
+	l := &logCache{}
+	b := newTestBackfiller(l)
+	b.maxGap = time.Minute
+	now := time.Now().UnixNano()
+	b.Track(logEnvelope("a", now-int64(50*time.Second), "a"))
+	// Sources quiet for longer than the window before the outage are not read.
+	b.Track(logEnvelope("old", now-int64(3*time.Hour), "old"))
+	b.Track(logEnvelope("b", now-int64(2*time.Hour), "b"))
+
+	backfill(t, b)
+	sources := l.sources()
+	assert.Len(t, sources, 1)
+	if r, ok := sources["a"]; ok {
+		start, _ := strconv.ParseInt(r.URL.Query().Get("start_time"), 10, 64)
+		assert.Equal(t, now-int64(50*time.Second)+1, start)
+	}
+
+	l = &logCache{}
+	b = newTestBackfiller(l)
+	b.maxGap = time.Minute
+	b.Track(logEnvelope("a", now-int64(2*time.Hour), "a"))
+	backfill(t, b)
+	for _, r := range l.sources() {
+		start, _ := strconv.ParseInt(r.URL.Query().Get("start_time"), 10, 64)
+		assert.True(t, start >= now-int64(time.Minute), start)
+	}
+}
+
+func TestBackfillSkipsWhenOtherStreamsReceived(t *testing.T) {
+	l := &logCache{}
+	b := newTestBackfiller(l)
+	b.Track(logEnvelope("a", time.Now().UnixNano(), "a"))
+	assert.Empty(t, backfill(t, b))
+	assert.Empty(t, l.sources())
+	assert.Nil(t, b.seen)
+}
+
+func TestBackfillDeduplicatesBothSides(t *testing.T) {
+	now := time.Now().UnixNano()
+	delivered := logEnvelope("a", now-int64(5*time.Second), "delivered by the stream")
+	missed := logEnvelope("a", now-int64(4*time.Second), "missed")
+	l := &logCache{envelopes: map[string][]*loggregator_v2.Envelope{"a": {delivered, missed}}}
+	b := newTestBackfiller(l)
+	b.Track(logEnvelope("a", now-int64(10*time.Second), "before"))
+	// The reconnected stream delivers an envelope of the outage before the backfill reads it.
+	l.onRead = func(string) { assert.False(t, b.Track(delivered)) }
+
+	routed := backfill(t, b)
+	if assert.Len(t, routed, 1) {
+		assert.Equal(t, "missed", string(routed[0].GetLog().GetPayload()))
+	}
+	// The stream skips the envelope the backfill routed.
+	assert.True(t, b.Track(missed))
+	assert.False(t, b.Track(logEnvelope("a", now, "new")))
+
+	// Deduplication ends once the stream is past the outage.
+	assert.False(t, b.Track(logEnvelope("a", now+int64(time.Minute), "later")))
+	assert.Nil(t, b.seen)
+	assert.False(t, b.Track(missed))
+}
+
+func TestBackfillErrors(t *testing.T) {
+	now := time.Now().UnixNano()
+	l := &logCache{
+		envelopes: map[string][]*loggregator_v2.Envelope{"ok": {logEnvelope("ok", now-int64(time.Second), "ok")}},
+		errors:    map[string]error{"failing": errors.New("connection refused")},
+	}
+	b := newTestBackfiller(l)
+	for _, source := range []string{"ok", "failing", "unavailable"} {
+		b.Track(logEnvelope(source, now-int64(10*time.Second), source))
+	}
+
+	routed := backfill(t, b)
+	assert.Len(t, l.sources(), 3)
+	if assert.Len(t, routed, 1) {
+		assert.Equal(t, "ok", routed[0].GetSourceId())
+	}
+
+	// A backfill runs again after a failed one.
+	l.errors = nil
+	b.Track(logEnvelope("ok", now-int64(5*time.Second), "ok"))
+	backfill(t, b)
+	assert.Len(t, l.requests, 6)
+}
+
+func TestBackfillLimitsSources(t *testing.T) {
+	l := &logCache{}
+	b := newTestBackfiller(l)
+	b.maxSources = 2
+	now := time.Now().UnixNano()
+	for i, source := range []string{"a", "b", "c", "d"} {
+		b.Track(logEnvelope(source, now-int64(time.Duration(10-i)*time.Second), source))
+	}
+
+	backfill(t, b)
+	sources := l.sources()
+	assert.Len(t, sources, 2)
+	assert.Contains(t, sources, "c")
+	assert.Contains(t, sources, "d")
+}
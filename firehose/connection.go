@@ -0,0 +1,253 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package firehose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/go-loggregator"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/firehose/httpfirehose"
+)
+
+// State of a stream's RLP Gateway connection.
+type State int
+
+// Connection states. A stream starts connecting, moves to streaming once the RLP Gateway
+// accepts the request and backs off after a failed request before connecting again.
+const (
+	StateConnecting State = iota
+	StateStreaming
+	StateBackoff
+	StateStopped
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateStreaming:
+		return "streaming"
+	case StateBackoff:
+		return "backing off"
+	}
+	return "stopped"
+}
+
+// ErrorClass groups connection failures by cause.
+type ErrorClass string
+
+// Connection error classes.
+const (
+	// ErrorAuth is a UAA token failure or a 401/403 from the RLP Gateway.
+	ErrorAuth ErrorClass = "auth"
+	// ErrorNetwork is a failure to reach the RLP Gateway or a dropped connection.
+	ErrorNetwork ErrorClass = "network"
+	// ErrorGateway is any other unexpected response from the RLP Gateway.
+	ErrorGateway ErrorClass = "gateway"
+)
+
+// ConnectionError is a classified RLP Gateway connection failure.
+type ConnectionError struct {
+	Class ErrorClass
+	Err   error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("%s error: %s", e.Class, e.Err.Error())
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// classify a request error or RLP Gateway response, returning nil for a successful connection.
+func classify(resp *http.Response, err error) *ConnectionError {
+	if err != nil {
+		if errors.Is(err, httpfirehose.ErrToken) {
+			return &ConnectionError{Class: ErrorAuth, Err: err}
+		}
+		return &ConnectionError{Class: ErrorNetwork, Err: err}
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return &ConnectionError{Class: ErrorAuth, Err: fmt.Errorf("unexpected status code %d", resp.StatusCode)}
+	}
+	return &ConnectionError{Class: ErrorGateway, Err: fmt.Errorf("unexpected status code %d", resp.StatusCode)}
+}
+
+// Connection is the RLP Gateway HTTP client of a stream. The loggregator client reconnects
+// in a loop without delay, so every request goes through the connection state machine,
+// waiting a jittered exponential backoff after failures.
+type Connection struct {
+	doer      loggregator.Doer
+	initial   time.Duration
+	max       time.Duration
+	lock      *sync.Mutex
+	state     State
+	since     time.Time
+	streamed  time.Time
+	attempts  int
+	lastError *ConnectionError
+	onChange  func(from, to State, err *ConnectionError)
+}
+
+// NewConnection sending requests through doer.
+func NewConnection(doer loggregator.Doer, initial, max time.Duration) *Connection {
+	return &Connection{
+		doer:     doer,
+		initial:  initial,
+		max:      max,
+		lock:     &sync.Mutex{},
+		state:    StateStopped,
+		since:    time.Now(),
+		streamed: time.Now(),
+	}
+}
+
+// Do satisfies loggregator.Doer.
+func (c *Connection) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	c.set(StateConnecting, nil)
+
+	resp, err := c.doer.Do(req)
+	if ctx.Err() != nil {
+		return resp, err
+	}
+	if cerr := classify(resp, err); cerr != nil {
+		c.fail(cerr)
+		return resp, err
+	}
+	c.set(StateStreaming, nil)
+	resp.Body = &body{ReadCloser: resp.Body, conn: c, ctx: ctx}
+	return resp, nil
+}
+
+// Received resets the backoff once envelopes arrive, a connection that is accepted and
+// then dropped straight away keeps backing off.
+func (c *Connection) Received() {
+	c.lock.Lock()
+	c.attempts = 0
+	c.lock.Unlock()
+}
+
+// Stop marks the connection stopped, a new request connects without waiting.
+func (c *Connection) Stop() {
+	c.set(StateStopped, nil)
+}
+
+// State returns the current state, how long the connection has been in it and the last error.
+func (c *Connection) State() (State, time.Duration, *ConnectionError) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.state, time.Since(c.since), c.lastError
+}
+
+// SinceStreaming returns how long it has been since the connection was streaming, zero while streaming.
+func (c *Connection) SinceStreaming() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.state == StateStreaming {
+		return 0
+	}
+	return time.Since(c.streamed)
+}
+
+// Backoff returns the jittered delay before the next attempt after n consecutive failures,
+// between half and the full exponential delay, capped at max.
+func (c *Connection) Backoff(n int) time.Duration {
+	if n < 1 {
+		return 0
+	}
+	d := c.initial
+	for i := 1; i < n && d < c.max; i++ {
+		d *= 2
+	}
+	if d > c.max {
+		d = c.max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (c *Connection) wait(ctx context.Context) error {
+	c.lock.Lock()
+	state, attempts := c.state, c.attempts
+	c.lock.Unlock()
+	if state != StateBackoff {
+		return nil
+	}
+
+	t := time.NewTimer(c.Backoff(attempts))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (c *Connection) fail(err *ConnectionError) {
+	c.lock.Lock()
+	c.attempts++
+	c.lastError = err
+	c.lock.Unlock()
+	c.set(StateBackoff, err)
+}
+
+func (c *Connection) set(s State, err *ConnectionError) {
+	c.lock.Lock()
+	from := c.state
+	if from == s {
+		c.lock.Unlock()
+		return
+	}
+	c.state = s
+	c.since = time.Now()
+	if s == StateStreaming {
+		c.lastError = nil
+	}
+	if from == StateStreaming || s == StateStreaming {
+		c.streamed = c.since
+	}
+	c.lock.Unlock()
+	if c.onChange != nil {
+		c.onChange(from, s, err)
+	}
+}
+
+// body moves the connection to backing off when the RLP Gateway stream ends.
+type body struct {
+	io.ReadCloser
+	conn *Connection
+	ctx  context.Context
+	once sync.Once
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && b.ctx.Err() == nil {
+		b.once.Do(func() {
+			if err == io.EOF {
+				// The RLP Gateway closes streams periodically, reconnect without waiting.
+				b.conn.Received()
+				b.conn.set(StateConnecting, nil)
+				return
+			}
+			b.conn.fail(&ConnectionError{Class: ErrorNetwork, Err: err})
+		})
+	}
+	return n, err
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package firehose
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/firehose/httpfirehose"
+	"github.com/stretchr/testify/assert"
+)
+
+type doerFunc func(*http.Request) (*http.Response, error)
+
+func (d doerFunc) Do(r *http.Request) (*http.Response, error) {
+	return d(r)
+}
+
+func response(code int) *http.Response {
+	return &http.Response{StatusCode: code, Body: ioutil.NopCloser(strings.NewReader(""))}
+}
+
+func TestBackoffIsJitteredAndCapped(t *testing.T) {
+	c := NewConnection(nil, 100*time.Millisecond, time.Second)
+	assert.Equal(t, time.Duration(0), c.Backoff(0))
+	for i := 0; i < 100; i++ {
+		d := c.Backoff(1)
+		assert.True(t, d >= 50*time.Millisecond && d <= 100*time.Millisecond, d)
+		d = c.Backoff(3)
+		assert.True(t, d >= 200*time.Millisecond && d <= 400*time.Millisecond, d)
+		d = c.Backoff(30)
+		assert.True(t, d >= 500*time.Millisecond && d <= time.Second, d)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	assert.Nil(t, classify(response(http.StatusOK), nil))
+	assert.Equal(t, ErrorAuth, classify(response(http.StatusUnauthorized), nil).Class)
+	assert.Equal(t, ErrorAuth, classify(response(http.StatusForbidden), nil).Class)
+	assert.Equal(t, ErrorGateway, classify(response(http.StatusBadGateway), nil).Class)
+	assert.Equal(t, ErrorAuth, classify(nil, fmt.Errorf("%w: invalid client", httpfirehose.ErrToken)).Class)
+	assert.Equal(t, ErrorNetwork, classify(nil, errors.New("connection refused")).Class)
+}
+
+func TestConnectionStates(t *testing.T) {
+	code := http.StatusBadGateway
+	c := NewConnection(doerFunc(func(*http.Request) (*http.Response, error) {
+		return response(code), nil
+	}), time.Millisecond, time.Millisecond)
+	req, _ := http.NewRequest(http.MethodGet, "http://rlp/v2/read", nil)
+
+	c.Do(req)
+	state, _, err := c.State()
+	assert.Equal(t, StateBackoff, state)
+	assert.Equal(t, ErrorGateway, err.Class)
+
+	code = http.StatusOK
+	resp, _ := c.Do(req)
+	state, _, err = c.State()
+	assert.Equal(t, StateStreaming, state)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), c.SinceStreaming())
+
+	// The RLP Gateway closing the stream reconnects without backing off.
+	io.Copy(ioutil.Discard, resp.Body)
+	state, _, _ = c.State()
+	assert.Equal(t, StateConnecting, state)
+}
@@ -4,40 +4,86 @@
 package firehose
 
 import (
+	"context"
+	"sync/atomic"
+
 	"code.cloudfoundry.org/go-diodes"
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
 )
 
 // OneToOneEnvelope ...
 type OneToOneEnvelope struct {
-	d *diodes.Poller
+	d         *diodes.ManyToOne
+	notify    chan struct{}
+	pending   int64
+	highWater int64
+	spill     *Spill
+	onSpill   func(error)
 }
 
 // NewOneToOneEnvelope ...
 func NewOneToOneEnvelope(size int, alerter diodes.Alerter) *OneToOneEnvelope {
-	return &OneToOneEnvelope{
-		d: diodes.NewPoller(diodes.NewManyToOne(size, alerter)),
+	d := &OneToOneEnvelope{
+		notify: make(chan struct{}, 1),
+		// Leave room for concurrent writers checking the pending count at the same time.
+		highWater: int64(size) * 9 / 10,
 	}
+	d.d = diodes.NewManyToOne(size, diodes.AlertFunc(func(missed int) {
+		atomic.AddInt64(&d.pending, -int64(missed))
+		alerter.Alert(missed)
+	}))
+	return d
+}
+
+// SetSpill sends envelopes to the disk backed queue while the diode is close to full,
+// and until the spilled envelopes were read, instead of overwriting unread envelopes.
+// onError is called when an envelope can't be spilled.
+func (d *OneToOneEnvelope) SetSpill(s *Spill, onError func(error)) {
+	d.spill = s
+	d.onSpill = onError
 }
 
-// Set inserts the given V2 envelope into the diode.
+// Set inserts the given V2 envelope into the diode and wakes a blocked reader.
 func (d *OneToOneEnvelope) Set(data *loggregator_v2.Envelope) {
-	d.d.Set(diodes.GenericDataType(data))
+	if d.spill != nil && (d.spill.Len() > 0 || atomic.LoadInt64(&d.pending) >= d.highWater) {
+		if err := d.spill.Write(data); err != nil {
+			d.onSpill(err)
+		}
+	} else {
+		atomic.AddInt64(&d.pending, 1)
+		d.d.Set(diodes.GenericDataType(data))
+	}
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
 }
 
-// TryNext returns the next V2 envelope to be read from the diode.  If the
-// diode is empty it will return a nil envelope and false for the bool.
+// TryNext returns the next V2 envelope to be read from the diode, then from the spill
+// queue. If both are empty it will return a nil envelope and false for the bool.
 func (d *OneToOneEnvelope) TryNext() (*loggregator_v2.Envelope, bool) {
 	if data, notEmpty := d.d.TryNext(); notEmpty {
+		atomic.AddInt64(&d.pending, -1)
 		return (*loggregator_v2.Envelope)(data), true
 	}
+	if d.spill != nil {
+		return d.spill.Read()
+	}
 	return nil, false
 }
 
 // Next will return the next V2 envelope to be read from the diode. If the
 // diode is empty this method will block until an envelope is available to be
-// read.
-func (d *OneToOneEnvelope) Next() *loggregator_v2.Envelope {
-	data := d.d.Next()
-	return (*loggregator_v2.Envelope)(data)
+// read or the context is done, in which case it returns nil.
+func (d *OneToOneEnvelope) Next(ctx context.Context) *loggregator_v2.Envelope {
+	for {
+		if e, notEmpty := d.TryNext(); notEmpty {
+			return e
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-d.notify:
+		}
+	}
 }
@@ -0,0 +1,128 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package firehose
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/go-diodes"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextBlocksUntilSet(t *testing.T) {
+	d := NewOneToOneEnvelope(10, diodes.AlertFunc(func(int) {}))
+	result := make(chan *loggregator_v2.Envelope)
+	go func() {
+		result <- d.Next(context.Background())
+	}()
+
+	select {
+	case <-result:
+		t.Fatal("Next returned before an envelope was set")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	e := &loggregator_v2.Envelope{SourceId: "source"}
+	d.Set(e)
+	assert.Equal(t, e, <-result)
+}
+
+func TestNextReturnsNilWhenCancelled(t *testing.T) {
+	d := NewOneToOneEnvelope(10, diodes.AlertFunc(func(int) {}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Nil(t, d.Next(ctx))
+}
+
+func TestNextDrainsBeforeCancel(t *testing.T) {
+	d := NewOneToOneEnvelope(10, diodes.AlertFunc(func(int) {}))
+	d.Set(&loggregator_v2.Envelope{SourceId: "a"})
+	d.Set(&loggregator_v2.Envelope{SourceId: "b"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, "a", d.Next(ctx).GetSourceId())
+	assert.Equal(t, "b", d.Next(ctx).GetSourceId())
+	assert.Nil(t, d.Next(ctx))
+}
+
+func TestSpillAbsorbsOverflow(t *testing.T) {
+	spill, err := NewSpill(t.TempDir(), 1024*1024, time.Minute)
+	assert.NoError(t, err)
+	dropped := 0
+	d := NewOneToOneEnvelope(10, diodes.AlertFunc(func(missed int) { dropped += missed }))
+	d.SetSpill(spill, func(err error) { t.Fatal(err) })
+
+	for i := 0; i < 100; i++ {
+		d.Set(&loggregator_v2.Envelope{SourceId: "source", InstanceId: fmt.Sprint(i)})
+	}
+	assert.True(t, spill.Len() > 0)
+
+	for i := 0; i < 100; i++ {
+		e, ok := d.TryNext()
+		assert.True(t, ok)
+		assert.Equal(t, fmt.Sprint(i), e.InstanceId)
+	}
+	_, ok := d.TryNext()
+	assert.False(t, ok)
+	assert.Equal(t, 0, dropped)
+	assert.Equal(t, int64(0), spill.Len())
+}
+
+func TestSpillDropsExpiredEnvelopes(t *testing.T) {
+	spill, err := NewSpill(t.TempDir(), 1024*1024, 10*time.Millisecond)
+	assert.NoError(t, err)
+	assert.NoError(t, spill.Write(&loggregator_v2.Envelope{SourceId: "old"}))
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, spill.Write(&loggregator_v2.Envelope{SourceId: "new"}))
+
+	e, ok := spill.Read()
+	assert.True(t, ok)
+	assert.Equal(t, "new", e.SourceId)
+	assert.Equal(t, int64(1), spill.Expired)
+}
+
+// spilledFirehose with envelopes in its diode and spill queue.
+func spilledFirehose(t *testing.T) *Firehose {
+	spill, err := NewSpill(t.TempDir(), 1024*1024, time.Minute)
+	assert.NoError(t, err)
+	f := newTestBackfiller(&logCache{}).firehose
+	f.spill = spill
+	f.Queue.SetSpill(spill, func(err error) { t.Fatal(err) })
+	for i := 0; i < 200; i++ {
+		f.Queue.Set(&loggregator_v2.Envelope{SourceId: "source", InstanceId: fmt.Sprint(i)})
+	}
+	assert.True(t, spill.Len() > 0)
+	return f
+}
+
+func TestCloseDrainsSpill(t *testing.T) {
+	f := spilledFirehose(t)
+	// The router reads the queue until it is closed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	routed := make(chan *loggregator_v2.Envelope, 200)
+	go func() {
+		for e := f.Queue.Next(ctx); e != nil; e = f.Queue.Next(ctx) {
+			routed <- e
+		}
+	}()
+	f.drainSpill(time.Second)
+	assert.Equal(t, int64(0), f.spill.Len())
+	assert.Equal(t, int64(0), atomic.LoadInt64(&f.spill.Dropped))
+	assert.Eventually(t, func() bool { return len(routed) == 200 }, time.Second, time.Millisecond)
+}
+
+func TestCloseDropsSpillAfterTimeout(t *testing.T) {
+	f := spilledFirehose(t)
+	queued := f.spill.Len()
+	f.drainSpill(20 * time.Millisecond)
+	assert.NoError(t, f.spill.Close())
+	assert.Equal(t, queued, atomic.LoadInt64(&f.spill.Dropped))
+	assert.Equal(t, int64(0), f.spill.Len())
+}
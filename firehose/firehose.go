@@ -4,146 +4,334 @@
 package firehose
 
 import (
-	"context"
+	"crypto/tls"
+	"fmt"
 	"log"
 	"os"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/cloudfoundry/go-loggregator"
 
 	"code.cloudfoundry.org/go-diodes"
-	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/api"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/firehose/httpfirehose"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/firehose/syslog"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/logger"
 )
 
 // Firehose Object...
 type Firehose struct {
-	log        *logger.Logger
-	config     *config.Config
-	nozzle     *loggregator.RLPGatewayClient
-	eventsChan chan *loggregator_v2.Envelope
-	closeChan  chan bool
-	Queue      *OneToOneEnvelope
-	EventCount int64
-	cancel     context.CancelFunc
+	log         *logger.Logger
+	config      *config.Config
+	doer        loggregator.Doer
+	rlpLog      *log.Logger
+	closeChan   chan bool
+	Queue       *OneToOneEnvelope
+	Streams     []*Stream
+	recorder    *Recorder
+	spill       *Spill
+	backfill    *Backfiller
+	syslog      *syslog.Listener
+	scoped      bool
+	sources     []string
+	resolve     func() ([]string, error)
+	sync        *sync.RWMutex
+	sourcesChan chan bool
 }
 
 // Close Firehose
 func (f *Firehose) Close() {
-	f.cancel()
+	for _, s := range f.Streams {
+		s.Stop()
+	}
+	if f.syslog != nil {
+		f.syslog.Close()
+	}
+	if f.sourcesChan != nil {
+		f.sourcesChan <- true
+	}
 	f.closeChan <- true
+	if f.spill != nil {
+		f.drainSpill(time.Duration(f.config.GetInt("FIREHOSE_SPILL_DRAIN_SECS")) * time.Second)
+		f.spill.Close()
+	}
+	if f.recorder != nil {
+		if err := f.recorder.Close(); err != nil {
+			f.log.Errorf("failed to close firehose recording: %s", err.Error())
+		}
+	}
 	f.log.Info("closed firehose consumer")
 }
 
-// GetEventCount ...
-func (f *Firehose) GetEventCount() int64 {
-	return atomic.LoadInt64(&f.EventCount)
+// drainSpill waits for the router, which keeps reading the queue until it is closed, to
+// read the spilled envelopes. Envelopes left after the timeout are dropped.
+func (f *Firehose) drainSpill(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for f.spill.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := f.spill.Len(); n > 0 {
+		atomic.AddInt64(&f.spill.Dropped, n)
+		f.log.Warnf("dropped %d spilled envelopes not processed within %v of closing", n, timeout)
+	}
+}
+
+// GetEventCount returns the number of envelopes received across all streams and the syslog listener.
+func (f *Firehose) GetEventCount() (count int64) {
+	for _, s := range f.Streams {
+		count += s.GetEventCount()
+	}
+	if f.syslog != nil {
+		count += f.syslog.GetEventCount()
+	}
+	return count
 }
 
 // ResetEventCount ...
 func (f *Firehose) ResetEventCount() {
-	atomic.StoreInt64(&f.EventCount, 0)
+	for _, s := range f.Streams {
+		s.ResetEventCount()
+	}
+	if f.syslog != nil {
+		f.syslog.ResetEventCount()
+	}
 }
 
 // Start New Firehose
 func Start() *Firehose {
 
-	var errorChan <-chan error
-
 	f := &Firehose{
-		log:        app.Get().Log,
-		config:     app.Get().Config,
-		eventsChan: make(chan *loggregator_v2.Envelope, 1024),
-		closeChan:  make(chan bool),
+		log:       app.Get().Log,
+		config:    app.Get().Config,
+		closeChan: make(chan bool),
+		sync:      &sync.RWMutex{},
 	}
 
 	f.log.Info("starting firehose")
 
+	// Firehouse non-blocking event queuing via PCF diodes. The diode accepts many writers,
+	// so every stream and the syslog listener set envelopes on it directly.
+	f.Queue = NewOneToOneEnvelope(
+		f.config.GetInt("FIREHOSE_DIODE_BUFFER"),
+		diodes.AlertFunc(func(missed int) {
+			f.log.Warnf("Firehose diode dropped %d messages", missed)
+		}))
+
+	// The spill queue absorbs bursts the diode can't hold on disk, instead of dropping envelopes.
+	if dir := f.config.GetString("FIREHOSE_SPILL_DIR"); dir != "" {
+		var err error
+		f.spill, err = NewSpill(
+			dir,
+			int64(f.config.GetInt("FIREHOSE_SPILL_MAX_MB"))*1024*1024,
+			time.Duration(f.config.GetInt("FIREHOSE_SPILL_MAX_AGE_SECS"))*time.Second,
+		)
+		if err != nil {
+			f.log.Fatalf("failed to create firehose spill queue: %s", err.Error())
+		}
+		f.Queue.SetSpill(f.spill, func(err error) {
+			f.log.Errorf("failed to spill envelope: %s", err.Error())
+		})
+		f.log.Infof("spilling firehose envelopes to %s when the diode is full", dir)
+	}
+
+	// Record mode writes every envelope batch to a file that can be replayed offline.
+	if path := f.config.GetString("FIREHOSE_RECORD_FILE"); path != "" {
+		var err error
+		if f.recorder, err = NewRecorder(path); err != nil {
+			f.log.Fatalf("failed to open firehose recording: %s", err.Error())
+		}
+		f.log.Infof("recording firehose envelopes to %s", path)
+	}
+
+	if f.config.GetInt("SYSLOG_PORT") > 0 {
+		f.startSyslog()
+	}
+
+	if f.config.GetBool("FIREHOSE_RLP_ENABLED") {
+		f.startStreams()
+	}
+
+	f.log.Info("firehose started")
+
+	go f.monitor()
+
+	return f
+
+}
+
+// startStreams connects FIREHOSE_STREAM_COUNT streams to the RLP Gateway.
+func (f *Firehose) startStreams() {
+
 	pcf, err := api.New()
 
 	if err != nil {
 		f.log.Fatalf("failed to start PCF Firehose: %s", err.Error())
 	}
 
-	f.ResetEventCount()
-
 	// Create a HTTP client which will be used to interact with the RLP Gateway
-	fh := httpfirehose.NewHttpFirehose(pcf, f.config)
+	fh, err := httpfirehose.NewHttpFirehose(pcf, f.config)
+	if err != nil {
+		f.log.Fatalf("invalid RLP Gateway TLS configuration: %s", err.Error())
+	}
 
+	f.doer = fh
 	// We will only pass a logger to the RLPGatewayClient if Debug level logging is enabled.
 	if f.config.GetString("LOG_LEVEL") == "DEBUG" {
-		log := log.New(os.Stdout, "RLP: ", log.Ldate|log.Ltime|log.Lshortfile)
-		// Create a RLP Gateway Client using the HTTP client created above (with logger).
-		f.nozzle = loggregator.NewRLPGatewayClient(f.config.GetString("CF_API_RLPG_URL"), loggregator.WithRLPGatewayClientLogger(log), loggregator.WithRLPGatewayHTTPClient(fh))
-	} else {
-		// Create a RLP Gateway Client using the HTTP client created above (no logger).
-		f.nozzle = loggregator.NewRLPGatewayClient(f.config.GetString("CF_API_RLPG_URL"), loggregator.WithRLPGatewayHTTPClient(fh))
+		f.rlpLog = log.New(os.Stdout, "RLP: ", log.Ldate|log.Ltime|log.Lshortfile)
 	}
 
-	f.startNozzle()
+	// A scoped subscription only selects the envelopes of the resolved source IDs.
+	if f.scoped = f.isScoped(); f.scoped {
+		f.resolve = f.resolveSources
+		ids, err := f.resolve()
+		if err != nil {
+			f.log.Fatalf("failed to resolve firehose source IDs: %s", err.Error())
+		}
+		f.updateSources(ids)
+		if len(ids) == 0 {
+			f.log.Warn("no firehose source IDs resolved, streams will connect once apps are found")
+		}
+		f.log.Infof("firehose subscription scoped to %d sources", len(ids))
+		f.sourcesChan = make(chan bool, 1)
+		go f.refreshSources()
+	}
 
-	f.Queue = NewOneToOneEnvelope(
-		f.config.GetInt("FIREHOSE_DIODE_BUFFER"),
-		diodes.AlertFunc(func(missed int) {
-			f.log.Warnf("Firehose diode dropped %d messages", missed)
-		}))
+	// Backfill reads the envelopes missed while reconnecting from the Log Cache.
+	if f.config.GetBool("FIREHOSE_BACKFILL") {
+		f.backfill = NewBackfiller(f, fh)
+		f.log.Infof("Log Cache backfill enabled using %s", f.config.GetString("CF_API_LOG_CACHE_URL"))
+	}
 
-	f.log.Info("firehose started")
+	count := f.config.GetInt("FIREHOSE_STREAM_COUNT")
+	if count < 1 {
+		count = 1
+	}
+	for i := 0; i < count; i++ {
+		s := newStream(f, i)
+		f.Streams = append(f.Streams, s)
+		s.Start()
+	}
 
-	// Firehouse non-blocking event queuing via PCF diodes
-	go func() {
+	f.log.Infof("started %d RLP Gateway stream(s)", count)
+}
 
-		for {
-			select {
+// startSyslog accepts CF syslog drains on SYSLOG_PORT, over TLS when a certificate is configured.
+func (f *Firehose) startSyslog() {
+	var tlsConfig *tls.Config
 
-			case err := <-errorChan:
-				app.Get().ErrorChan <- err
+	cert, err := f.config.GetPEM("SYSLOG_TLS_CERT")
+	if err != nil {
+		f.log.Fatalf("failed to read syslog TLS certificate: %s", err.Error())
+	}
+	key, err := f.config.GetPEM("SYSLOG_TLS_KEY")
+	if err != nil {
+		f.log.Fatalf("failed to read syslog TLS key: %s", err.Error())
+	}
+	if cert != nil || key != nil {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			f.log.Fatalf("invalid syslog TLS certificate and key: %s", err.Error())
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{pair},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
 
-			case <-f.closeChan:
-				f.log.Info("closed firehose")
-				return
+	f.syslog = syslog.New(fmt.Sprintf(":%d", f.config.GetInt("SYSLOG_PORT")), tlsConfig, f.Queue, f.log)
+	if err := f.syslog.Start(); err != nil {
+		f.log.Fatalf("failed to start syslog listener: %s", err.Error())
+	}
+	f.log.Infof("syslog listener started on %s (TLS: %t)", f.syslog.Addr(), tlsConfig != nil)
+}
 
-			case event := <-f.eventsChan:
-				f.Queue.Set(event)
-				atomic.AddInt64(&f.EventCount, 1)
-				f.log.Tracer("<")
+// newRLPClient creates a RLP Gateway client sending its requests through conn.
+func (f *Firehose) newRLPClient(conn *Connection) *loggregator.RLPGatewayClient {
+	opts := []loggregator.RLPGatewayClientOption{loggregator.WithRLPGatewayHTTPClient(conn)}
+	if f.rlpLog != nil {
+		opts = append(opts, loggregator.WithRLPGatewayClientLogger(f.rlpLog))
+	}
+	return loggregator.NewRLPGatewayClient(f.config.GetString("CF_API_RLPG_URL"), opts...)
+}
 
-			}
-		}
+// monitor reconnects any stream that has not received envelopes for FIREHOSE_RESTART_THRESH_SECS.
+func (f *Firehose) monitor() {
+	thresh := time.Duration(f.config.GetInt("FIREHOSE_RESTART_THRESH_SECS")) * time.Second
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
-	}()
+	for {
+		select {
 
-	return f
+		case <-f.closeChan:
+			f.log.Info("closed firehose")
+			return
 
+		case <-ticker.C:
+			if !f.hasSources() {
+				continue
+			}
+			for _, s := range f.Streams {
+				// Streams that are connecting or backing off are already reconnecting.
+				if state, _, _ := s.Conn.State(); state != StateStreaming {
+					continue
+				}
+				if s.Idle() > thresh {
+					f.log.Warnf("Stream %d has been empty for > %v.  Restarting firehose stream.", s.ID, thresh)
+					s.Restart()
+				}
+			}
+		}
+	}
 }
 
-// StartNozzle creates a context, connects to the RLP Gateway, and places envelopes on the eventsChan.
-func (f *Firehose) startNozzle() {
-	ctx, cancel := context.WithCancel(context.Background())
-	f.cancel = cancel
+// RestartNozzle restarts every stream's RLP Gateway connection.
+func (f *Firehose) RestartNozzle() {
+	for _, s := range f.Streams {
+		s.Restart()
+	}
+}
 
-	es := f.nozzle.Stream(ctx, &loggregator_v2.EgressBatchRequest{
-		ShardId:   f.config.GetString("FIREHOSE_ID"),
-		Selectors: f.config.GetSelectors(),
-	})
+// Health satisfies healthcheck.Checker. The firehose is unhealthy when no stream has been
+// streaming for FIREHOSE_UNHEALTHY_SECS, the status holds each stream's connection state.
+func (f *Firehose) Health() (status []string, healthy bool) {
+	if len(f.Streams) == 0 {
+		return nil, true
+	}
+	if !f.hasSources() {
+		return []string{"firehose: no source IDs resolved"}, true
+	}
 
-	go func() {
-		for ctx.Err() == nil {
-			for _, e := range es() {
-				f.eventsChan <- e
-			}
+	thresh := time.Duration(f.config.GetInt("FIREHOSE_UNHEALTHY_SECS")) * time.Second
+	for _, s := range f.Streams {
+		state, d, err := s.Conn.State()
+		line := fmt.Sprintf("stream %d: %s for %v", s.ID, state, d.Round(time.Second))
+		if err != nil {
+			line += fmt.Sprintf(" (%s)", err.Error())
+		}
+		status = append(status, line)
+		if s.Conn.SinceStreaming() < thresh {
+			healthy = true
 		}
-	}()
+	}
+	return status, healthy
 }
 
-// RestartNozzle calls the context cancel function, then starts the nozzle again.
-func (f *Firehose) RestartNozzle() {
-	// Cancel the context, which will stop the current HTTP requests.
-	f.cancel()
-	// Restart the nozzle RLP Gateway connection
-	f.startNozzle()
+// StreamStats returns the per stream event and reconnect counters, used for debug logging.
+func (f *Firehose) StreamStats() (stats []string) {
+	for _, s := range f.Streams {
+		state, d, _ := s.Conn.State()
+		stats = append(stats, fmt.Sprintf("stream %d: %s for %v, %d events, %d reconnects", s.ID, state, d.Round(time.Second), s.GetEventCount(), s.GetReconnects()))
+	}
+	if f.spill != nil {
+		stats = append(stats, fmt.Sprintf("spill: %d queued, %d dropped, %d expired", f.spill.Len(), atomic.LoadInt64(&f.spill.Dropped), atomic.LoadInt64(&f.spill.Expired)))
+	}
+	if f.syslog != nil {
+		stats = append(stats, fmt.Sprintf("syslog: %d events, %d invalid messages", f.syslog.GetEventCount(), atomic.LoadInt64(&f.syslog.ErrorCount)))
+	}
+	return stats
 }
@@ -4,7 +4,8 @@
 package httpfirehose
 
 import (
-	"crypto/tls"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -14,6 +15,9 @@ import (
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
 )
 
+// ErrToken is returned by Do when no UAA token could be fetched.
+var ErrToken = errors.New("failed to get UAA token")
+
 // HttpFirehose Object
 type HttpFirehose struct {
 	apiClient  *api.Client
@@ -21,28 +25,28 @@ type HttpFirehose struct {
 }
 
 // NewHttpFirehose creates a new object with the correct TLS configuration
-func NewHttpFirehose(c *api.Client, conf *config.Config) *HttpFirehose {
+func NewHttpFirehose(c *api.Client, conf *config.Config) (*HttpFirehose, error) {
+	tlsConfig, err := conf.GetTLSConfig()
+	if err != nil {
+		return nil, err
+	}
 	return &HttpFirehose{
 		apiClient: c,
 		httpClient: &http.Client{
 			Transport: &http.Transport{
 				DisableKeepAlives: true,
-				// SECURITY: InsecureSkipVerify should remain false in production
-				// to prevent man-in-the-middle attacks
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: conf.GetBool("CF_SKIP_SSL"),
-				},
+				TLSClientConfig:   tlsConfig,
 			},
 			Timeout: time.Duration(conf.GetInt("FIREHOSE_HTTP_TIMEOUT_MINS")) * time.Minute,
 		},
-	}
+	}, nil
 }
 
 // Do will add the token as an authorization header on all HTTP requests from FirehoseHttp
 func (h *HttpFirehose) Do(req *http.Request) (*http.Response, error) {
 	token, err := h.apiClient.Client.GetToken()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %s", ErrToken, err.Error())
 	}
 	req.Header.Set("Authorization", token)
 	// Connection should stream for up to 14 minutes.
@@ -0,0 +1,110 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package firehose
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/golang/protobuf/jsonpb"
+)
+
+// Recorder writes the envelope batches received from the RLP Gateway to a file,
+// one JSON encoded loggregator_v2.EnvelopeBatch per line.
+type Recorder struct {
+	file      *os.File
+	w         *bufio.Writer
+	marshaler *jsonpb.Marshaler
+	sync      *sync.Mutex
+}
+
+// NewRecorder creates or appends to the recording file at path.
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{
+		file:      file,
+		w:         bufio.NewWriter(file),
+		marshaler: &jsonpb.Marshaler{},
+		sync:      &sync.Mutex{},
+	}, nil
+}
+
+// Record appends a batch of envelopes to the recording.
+func (r *Recorder) Record(batch []*loggregator_v2.Envelope) error {
+	r.sync.Lock()
+	defer r.sync.Unlock()
+	if err := r.marshaler.Marshal(r.w, &loggregator_v2.EnvelopeBatch{Batch: batch}); err != nil {
+		return err
+	}
+	return r.w.WriteByte('\n')
+}
+
+// Close flushes and closes the recording file.
+func (r *Recorder) Close() error {
+	r.sync.Lock()
+	defer r.sync.Unlock()
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+	return r.file.Close()
+}
+
+// Replay reads a recording and calls fn for every envelope in it. Each line can be an
+// envelope batch written by the Recorder or a single envelope, like tests/integration/fhout.json.
+// speed scales the delay between envelope timestamps: 1 replays at the original speed, 10
+// ten times faster and 0 as fast as fn accepts envelopes.
+func Replay(path string, speed float64, fn func(*loggregator_v2.Envelope)) (count int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var last int64
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		batch, err := unmarshalLine(scanner.Bytes())
+		if err != nil {
+			return count, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		for _, e := range batch {
+			if speed > 0 && last > 0 && e.GetTimestamp() > last {
+				time.Sleep(time.Duration(float64(e.GetTimestamp()-last) / speed))
+			}
+			if e.GetTimestamp() > last {
+				last = e.GetTimestamp()
+			}
+			fn(e)
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+// unmarshalLine decodes a recorded batch, falling back to a single envelope.
+func unmarshalLine(line []byte) ([]*loggregator_v2.Envelope, error) {
+	if bytes.Contains(line, []byte(`"batch"`)) {
+		var eb loggregator_v2.EnvelopeBatch
+		if err := jsonpb.Unmarshal(bytes.NewReader(line), &eb); err == nil {
+			return eb.GetBatch(), nil
+		}
+	}
+	var e loggregator_v2.Envelope
+	if err := jsonpb.Unmarshal(bytes.NewReader(line), &e); err != nil {
+		return nil, err
+	}
+	return []*loggregator_v2.Envelope{&e}, nil
+}
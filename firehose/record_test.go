@@ -0,0 +1,92 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package firehose
+
+import (
+	"path/filepath"
+	"testing"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnmarshalLine(t *testing.T) {
+	batch, err := unmarshalLine([]byte(`{"batch":[{"source_id":"a"},{"source_id":"b"}]}`))
+	assert.NoError(t, err)
+	if !assert.Len(t, batch, 2) {
+		return
+	}
+	assert.Equal(t, "a", batch[0].GetSourceId())
+	assert.Equal(t, "b", batch[1].GetSourceId())
+
+	batch, err = unmarshalLine([]byte(`{"source_id":"c","log":{"payload":"aGVsbG8=","type":"OUT"}}`))
+	assert.NoError(t, err)
+	if !assert.Len(t, batch, 1) {
+		return
+	}
+	assert.Equal(t, "c", batch[0].GetSourceId())
+	assert.Equal(t, "hello", string(batch[0].GetLog().GetPayload()))
+
+	_, err = unmarshalLine([]byte(`{"source_id":`))
+	assert.Error(t, err)
+}
+
+func TestReplayEnvelopes(t *testing.T) {
+	var replayed []*loggregator_v2.Envelope
+	count, err := Replay("testdata/envelopes.json", 0, func(e *loggregator_v2.Envelope) {
+		replayed = append(replayed, e)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	if !assert.Len(t, replayed, 3) {
+		return
+	}
+	assert.Equal(t, uint64(12), replayed[0].GetCounter().GetTotal())
+	assert.Equal(t, 42.0, replayed[1].GetGauge().GetMetrics()["file_descriptors"].GetValue())
+	assert.Equal(t, "hello", string(replayed[2].GetLog().GetPayload()))
+}
+
+func TestRecordReplayRoundTrip(t *testing.T) {
+	var fixture []*loggregator_v2.Envelope
+	_, err := Replay("testdata/envelopes.json", 0, func(e *loggregator_v2.Envelope) {
+		fixture = append(fixture, e)
+	})
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "recording.json")
+	r, err := NewRecorder(path)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Record(fixture[:2]))
+	assert.NoError(t, r.Record(fixture[2:]))
+	assert.NoError(t, r.Close())
+
+	var replayed []*loggregator_v2.Envelope
+	count, err := Replay(path, 0, func(e *loggregator_v2.Envelope) {
+		replayed = append(replayed, e)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, len(fixture), count)
+	if !assert.Len(t, replayed, len(fixture)) {
+		return
+	}
+	for i := range fixture {
+		assert.Equal(t, fixture[i].String(), replayed[i].String())
+	}
+}
+
+func TestReplayReportsBadLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.json")
+	r, err := NewRecorder(path)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Record([]*loggregator_v2.Envelope{{SourceId: "a"}}))
+	_, err = r.w.WriteString("not json\n")
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+
+	count, err := Replay(path, 0, func(*loggregator_v2.Envelope) {})
+	assert.Equal(t, 1, count)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "line 2:")
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package firehose
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/cfapps"
+)
+
+// isScoped is true when the subscription is limited to source IDs.
+func (f *Firehose) isScoped() bool {
+	for _, s := range []string{
+		"FIREHOSE_SOURCE_ORGS",
+		"FIREHOSE_SOURCE_SPACES",
+		"FIREHOSE_SOURCE_APPS",
+		"FIREHOSE_SOURCE_IDS",
+	} {
+		if f.config.GetFilter(s) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSources resolves the scoped org, space and app names to app GUIDs through
+// the CFAppManager, after FIREHOSE_SOURCE_IDS.
+func (f *Firehose) resolveSources() ([]string, error) {
+	ids, err := cfapps.GetInstance().ResolveSourceIDs(
+		f.config.GetFilter("FIREHOSE_SOURCE_ORGS"),
+		f.config.GetFilter("FIREHOSE_SOURCE_SPACES"),
+		f.config.GetFilter("FIREHOSE_SOURCE_APPS"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return mergeSources(f.config.GetFilter("FIREHOSE_SOURCE_IDS"), ids), nil
+}
+
+// mergeSources lists the explicit source IDs first, so they are kept when the source IDs
+// are capped, followed by the resolved ones not already listed.
+func mergeSources(explicit []string, resolved []string) []string {
+	ids := make([]string, 0, len(explicit)+len(resolved))
+	listed := map[string]bool{}
+	for _, list := range [][]string{explicit, resolved} {
+		for _, id := range list {
+			if id = strings.TrimSpace(id); id != "" && !listed[id] {
+				listed[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// hasSources is false while a scoped subscription has no source IDs, streams must not
+// connect then as they would receive the whole foundation.
+func (f *Firehose) hasSources() bool {
+	f.sync.RLock()
+	defer f.sync.RUnlock()
+	return !f.scoped || len(f.sources) > 0
+}
+
+// selectors and shard ID of a stream, ok is false while a scoped subscription has no
+// source IDs. Source IDs are split into groups of FIREHOSE_SOURCE_IDS_PER_STREAM, each
+// with its own shard, and the streams select the groups round robin.
+func (f *Firehose) selectors(stream int) (s []*loggregator_v2.Selector, shardID string, ok bool) {
+	f.sync.RLock()
+	defer f.sync.RUnlock()
+	shardID = f.config.GetString("FIREHOSE_ID")
+	if !f.scoped {
+		return f.config.GetSelectors(), shardID, true
+	}
+	groups := f.sourceGroups()
+	if len(groups) == 0 {
+		return nil, "", false
+	}
+	if len(groups) > 1 {
+		shardID = fmt.Sprintf("%s-%d", shardID, stream%len(groups))
+	}
+	return f.config.GetSourceSelectors(groups[stream%len(groups)]), shardID, true
+}
+
+// sourceGroups of at most FIREHOSE_SOURCE_IDS_PER_STREAM source IDs. Callers hold sync.
+func (f *Firehose) sourceGroups() (groups [][]string) {
+	per := f.sourcesPerStream()
+	for i := 0; i < len(f.sources); i += per {
+		end := i + per
+		if end > len(f.sources) {
+			end = len(f.sources)
+		}
+		groups = append(groups, f.sources[i:end])
+	}
+	return groups
+}
+
+func (f *Firehose) sourcesPerStream() int {
+	if per := f.config.GetInt("FIREHOSE_SOURCE_IDS_PER_STREAM"); per > 0 {
+		return per
+	}
+	return 1
+}
+
+// updateSources stores the resolved source IDs, returning true when they changed. IDs
+// beyond what the streams can select are dropped with a warning, FIREHOSE_SOURCE_IDS
+// come first and are dropped last.
+func (f *Firehose) updateSources(ids []string) bool {
+	streams := f.config.GetInt("FIREHOSE_STREAM_COUNT")
+	if streams < 1 {
+		streams = 1
+	}
+	if capacity := streams * f.sourcesPerStream(); len(ids) > capacity {
+		f.log.Warnf(
+			"%d firehose source IDs resolved, only the first %d are selected by %d streams of %d source IDs, increase FIREHOSE_STREAM_COUNT",
+			len(ids), capacity, streams, f.sourcesPerStream(),
+		)
+		ids = ids[:capacity]
+	}
+	f.sync.Lock()
+	defer f.sync.Unlock()
+	if reflect.DeepEqual(f.sources, ids) {
+		return false
+	}
+	f.sources = ids
+	return true
+}
+
+// refreshSources resolves the scoped names every FIREHOSE_SOURCE_REFRESH_SECS and
+// restarts the streams when apps were added or removed.
+func (f *Firehose) refreshSources() {
+	ticker := time.NewTicker(time.Duration(f.config.GetInt("FIREHOSE_SOURCE_REFRESH_SECS")) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+
+		case <-f.sourcesChan:
+			return
+
+		case <-ticker.C:
+			f.refresh()
+		}
+	}
+}
+
+// refresh resolves the source IDs and restarts the streams when they changed.
+func (f *Firehose) refresh() {
+	ids, err := f.resolve()
+	if err != nil {
+		f.log.Errorf("failed to resolve firehose source IDs: %s", err.Error())
+		return
+	}
+	if f.updateSources(ids) {
+		f.log.Infof("firehose source IDs changed, restarting streams for %d sources", len(ids))
+		f.RestartNozzle()
+	}
+}
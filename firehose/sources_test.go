@@ -0,0 +1,178 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package firehose
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/go-diodes"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func newScopedFirehose(streams int, perStream int) *Firehose {
+	c := config.Get().Scoped("sources")
+	c.Set("FIREHOSE_ID", "nozzle")
+	c.Set("FIREHOSE_STREAM_COUNT", streams)
+	c.Set("FIREHOSE_SOURCE_IDS_PER_STREAM", perStream)
+	c.Set("ENABLED_ENVELOPE_TYPES", "LogMessage")
+	return &Firehose{
+		log:    logger.New(c),
+		config: c,
+		Queue:  NewOneToOneEnvelope(10, diodes.AlertFunc(func(int) {})),
+		scoped: true,
+		sync:   &sync.RWMutex{},
+		doer: doerFunc(func(*http.Request) (*http.Response, error) {
+			return response(http.StatusServiceUnavailable), nil
+		}),
+	}
+}
+
+func sourceIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("source-%02d", i)
+	}
+	return ids
+}
+
+// selected source IDs and shard ID of a stream.
+func selected(f *Firehose, stream int) (ids []string, shardID string) {
+	selectors, shardID, _ := f.selectors(stream)
+	for _, s := range selectors {
+		ids = append(ids, s.GetSourceId())
+	}
+	return ids, shardID
+}
+
+func TestSelectorsWithoutSourceIDs(t *testing.T) {
+	f := newScopedFirehose(1, 2)
+	_, _, ok := f.selectors(0)
+	assert.False(t, ok)
+	assert.False(t, f.hasSources())
+
+	f.scoped = false
+	selectors, shardID, ok := f.selectors(0)
+	assert.True(t, ok)
+	assert.Equal(t, "nozzle", shardID)
+	if assert.Len(t, selectors, 1) {
+		assert.Empty(t, selectors[0].GetSourceId())
+	}
+}
+
+func TestSelectorsSplitSourceIDs(t *testing.T) {
+	f := newScopedFirehose(4, 2)
+	f.updateSources(sourceIDs(2))
+	ids, shardID := selected(f, 3)
+	assert.Equal(t, []string{"source-00", "source-01"}, ids)
+	assert.Equal(t, "nozzle", shardID)
+
+	f.updateSources(sourceIDs(3))
+	ids, shardID = selected(f, 0)
+	assert.Equal(t, []string{"source-00", "source-01"}, ids)
+	assert.Equal(t, "nozzle-0", shardID)
+	ids, shardID = selected(f, 1)
+	assert.Equal(t, []string{"source-02"}, ids)
+	assert.Equal(t, "nozzle-1", shardID)
+	// Streams beyond the groups share their shards.
+	ids, shardID = selected(f, 2)
+	assert.Equal(t, []string{"source-00", "source-01"}, ids)
+	assert.Equal(t, "nozzle-0", shardID)
+}
+
+func TestUpdateSourcesCapsSourceIDs(t *testing.T) {
+	f := newScopedFirehose(2, 2)
+	assert.True(t, f.updateSources(sourceIDs(7)))
+	assert.Equal(t, sourceIDs(4), f.sources)
+	assert.False(t, f.updateSources(sourceIDs(5)))
+	ids, _ := selected(f, 1)
+	assert.Equal(t, []string{"source-02", "source-03"}, ids)
+}
+
+func TestExplicitSourceIDsAreKept(t *testing.T) {
+	f := newScopedFirehose(1, 3)
+	ids := mergeSources([]string{" rtr ", "uaa", "app-1"}, []string{"app-1", "app-2", "app-3"})
+	assert.Equal(t, []string{"rtr", "uaa", "app-1", "app-2", "app-3"}, ids)
+	f.updateSources(ids)
+	assert.Equal(t, []string{"rtr", "uaa", "app-1"}, f.sources)
+}
+
+func TestRefreshRestartsStreamsWhenSourcesChange(t *testing.T) {
+	f := newScopedFirehose(2, 1)
+	resolved, err := sourceIDs(1), error(nil)
+	f.resolve = func() ([]string, error) { return resolved, err }
+	f.updateSources(resolved)
+	for i := 0; i < 2; i++ {
+		f.Streams = append(f.Streams, newStream(f, i))
+	}
+	defer func() {
+		for _, s := range f.Streams {
+			s.Stop()
+		}
+	}()
+	reconnects := func() (n []int64) {
+		for _, s := range f.Streams {
+			n = append(n, s.GetReconnects())
+		}
+		return n
+	}
+
+	f.refresh()
+	assert.Equal(t, []int64{0, 0}, reconnects())
+
+	resolved = sourceIDs(2)
+	f.refresh()
+	assert.Equal(t, []int64{1, 1}, reconnects())
+	ids, shardID := selected(f, 1)
+	assert.Equal(t, []string{"source-01"}, ids)
+	assert.Equal(t, "nozzle-1", shardID)
+
+	// Sources are kept when they cannot be resolved.
+	err = errors.New("cf api unavailable")
+	f.refresh()
+	assert.Equal(t, []int64{1, 1}, reconnects())
+	assert.Equal(t, sourceIDs(2), f.sources)
+}
+
+func TestConcurrentRestartsLeaveOneConnection(t *testing.T) {
+	f := newScopedFirehose(1, 1)
+	f.updateSources(sourceIDs(1))
+	var open int64
+	f.doer = doerFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt64(&open, 1)
+		defer atomic.AddInt64(&open, -1)
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	})
+	s := newStream(f, 0)
+	s.Start()
+
+	// The monitor and source refreshes restart the stream at the same time, held while
+	// reading the selectors so the restarts overlap.
+	f.sync.Lock()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Restart()
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	f.sync.Unlock()
+	wg.Wait()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&open))
+	assert.Equal(t, int64(20), s.GetReconnects())
+
+	s.Stop()
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&open) == 0 }, time.Second, time.Millisecond)
+}
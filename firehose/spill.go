@@ -0,0 +1,236 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package firehose
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/golang/protobuf/proto"
+)
+
+// spillHeader is the spill time (unix nanoseconds) and the length of the marshaled envelope.
+const spillHeader = 12
+
+// Spill is a disk backed FIFO queue of envelopes, absorbing bursts the diode can't hold.
+// Records are appended to segment files in dir. When the queue exceeds maxBytes the oldest
+// segment is dropped, and envelopes spilled more than maxAge ago are skipped when read.
+type Spill struct {
+	Dropped      int64
+	Expired      int64
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+	maxAge       time.Duration
+	lock         *sync.Mutex
+	segments     []*segment
+	size         int64
+	count        int64
+	seq          int
+	writer       *bufio.Writer
+	writeFile    *os.File
+	reader       *bufio.Reader
+	readFile     *os.File
+}
+
+type segment struct {
+	path    string
+	size    int64
+	records int64
+}
+
+// NewSpill queue in dir, removing segments left by a previous run.
+func NewSpill(dir string, maxBytes int64, maxAge time.Duration) (*Spill, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	old, err := filepath.Glob(filepath.Join(dir, "*.spill"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range old {
+		os.Remove(path)
+	}
+
+	segmentBytes := maxBytes / 16
+	if segmentBytes < 1024*1024 {
+		segmentBytes = 1024 * 1024
+	}
+	if segmentBytes > maxBytes {
+		segmentBytes = maxBytes
+	}
+	return &Spill{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentBytes: segmentBytes,
+		maxAge:       maxAge,
+		lock:         &sync.Mutex{},
+	}, nil
+}
+
+// Len returns the number of envelopes in the queue.
+func (s *Spill) Len() int64 {
+	return atomic.LoadInt64(&s.count)
+}
+
+// Write appends an envelope to the queue.
+func (s *Spill) Write(e *loggregator_v2.Envelope) error {
+	data, err := proto.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.segments) == 0 || s.segments[len(s.segments)-1].size >= s.segmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	header := make([]byte, spillHeader)
+	binary.BigEndian.PutUint64(header, uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(header[8:], uint32(len(data)))
+	if _, err := s.writer.Write(header); err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(data); err != nil {
+		return err
+	}
+
+	n := int64(spillHeader + len(data))
+	seg := s.segments[len(s.segments)-1]
+	seg.size += n
+	seg.records++
+	s.size += n
+	atomic.AddInt64(&s.count, 1)
+
+	// Make room by dropping the oldest segments, never the one being written.
+	for s.size > s.maxBytes && len(s.segments) > 1 {
+		atomic.AddInt64(&s.Dropped, s.segments[0].records)
+		s.removeOldest()
+	}
+	return nil
+}
+
+// Read returns the oldest envelope in the queue, false when the queue is empty.
+func (s *Spill) Read() (*loggregator_v2.Envelope, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for atomic.LoadInt64(&s.count) > 0 {
+		seg := s.segments[0]
+		if s.reader == nil {
+			f, err := os.Open(seg.path)
+			if err != nil {
+				s.discard(seg)
+				continue
+			}
+			s.readFile = f
+			s.reader = bufio.NewReader(f)
+		}
+		if len(s.segments) == 1 {
+			s.writer.Flush()
+		}
+
+		header := make([]byte, spillHeader)
+		if _, err := io.ReadFull(s.reader, header); err != nil {
+			s.discard(seg)
+			continue
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[8:]))
+		if _, err := io.ReadFull(s.reader, data); err != nil {
+			s.discard(seg)
+			continue
+		}
+		seg.records--
+		atomic.AddInt64(&s.count, -1)
+		if seg.records == 0 && len(s.segments) > 1 {
+			s.removeOldest()
+		}
+
+		spilled := time.Unix(0, int64(binary.BigEndian.Uint64(header)))
+		if s.maxAge > 0 && time.Since(spilled) > s.maxAge {
+			atomic.AddInt64(&s.Expired, 1)
+			continue
+		}
+		e := &loggregator_v2.Envelope{}
+		if err := proto.Unmarshal(data, e); err != nil {
+			continue
+		}
+		s.reset()
+		return e, true
+	}
+	s.reset()
+	return nil, false
+}
+
+// Close the queue and remove its segments.
+func (s *Spill) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for len(s.segments) > 0 {
+		s.removeOldest()
+	}
+	return nil
+}
+
+// rotate starts a new segment for writes.
+func (s *Spill) rotate() error {
+	if s.writer != nil {
+		if err := s.writer.Flush(); err != nil {
+			return err
+		}
+		s.writeFile.Close()
+	}
+	s.seq++
+	path := filepath.Join(s.dir, fmt.Sprintf("%08d.spill", s.seq))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	s.writeFile = f
+	s.writer = bufio.NewWriter(f)
+	s.segments = append(s.segments, &segment{path: path})
+	return nil
+}
+
+// removeOldest deletes the segment being read.
+func (s *Spill) removeOldest() {
+	seg := s.segments[0]
+	if s.readFile != nil {
+		s.readFile.Close()
+		s.readFile, s.reader = nil, nil
+	}
+	if len(s.segments) == 1 && s.writer != nil {
+		s.writeFile.Close()
+		s.writeFile, s.writer = nil, nil
+	}
+	os.Remove(seg.path)
+	s.size -= seg.size
+	atomic.AddInt64(&s.count, -seg.records)
+	s.segments = s.segments[1:]
+}
+
+// discard a segment that can't be read, counting its remaining envelopes as dropped.
+func (s *Spill) discard(seg *segment) {
+	atomic.AddInt64(&s.Dropped, seg.records)
+	s.removeOldest()
+}
+
+// reset removes the last segment once everything was read, so the files don't keep growing.
+func (s *Spill) reset() {
+	if atomic.LoadInt64(&s.count) == 0 && len(s.segments) == 1 {
+		s.removeOldest()
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package firehose
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/cloudfoundry/go-loggregator"
+)
+
+// Stream is a single RLP Gateway connection. The streams of a Firehose share the same
+// FIREHOSE_ID shard, so the RLP Gateway balances envelopes across them, unless a scoped
+// subscription is split across streams, see Firehose.selectors.
+type Stream struct {
+	ID         int
+	EventCount int64
+	Reconnects int64
+	Conn       *Connection
+	firehose   *Firehose
+	nozzle     *loggregator.RLPGatewayClient
+	cancel     context.CancelFunc
+	lastEvent  int64
+	lock       *sync.Mutex
+	restarting *sync.Mutex
+}
+
+// newStream ...
+func newStream(f *Firehose, id int) *Stream {
+	s := &Stream{
+		ID:         id,
+		firehose:   f,
+		lock:       &sync.Mutex{},
+		restarting: &sync.Mutex{},
+	}
+	s.Conn = NewConnection(
+		f.doer,
+		time.Duration(f.config.GetInt("FIREHOSE_BACKOFF_INITIAL_MS"))*time.Millisecond,
+		time.Duration(f.config.GetInt("FIREHOSE_BACKOFF_MAX_SECS"))*time.Second,
+	)
+	s.Conn.onChange = s.stateChanged
+	s.nozzle = f.newRLPClient(s.Conn)
+	return s
+}
+
+// Start creates a context, connects to the RLP Gateway, and places envelopes on the Firehose queue.
+func (s *Stream) Start() {
+	selectors, shardID, ok := s.firehose.selectors(s.ID)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.lock.Lock()
+	s.cancel = cancel
+	s.lock.Unlock()
+	s.touch()
+
+	es := s.nozzle.Stream(ctx, &loggregator_v2.EgressBatchRequest{
+		ShardId:   shardID,
+		Selectors: selectors,
+	})
+
+	go func() {
+		for ctx.Err() == nil {
+			batch := es()
+			if s.firehose.recorder != nil && len(batch) > 0 {
+				if err := s.firehose.recorder.Record(batch); err != nil {
+					s.firehose.log.Errorf("failed to record envelopes: %s", err.Error())
+				}
+			}
+			for _, e := range batch {
+				atomic.AddInt64(&s.EventCount, 1)
+				if s.firehose.backfill != nil && s.firehose.backfill.Track(e) {
+					continue
+				}
+				s.firehose.Queue.Set(e)
+				s.firehose.log.Tracer("<")
+			}
+			if len(batch) > 0 {
+				s.Conn.Received()
+			}
+			s.touch()
+		}
+	}()
+}
+
+// Stop calls the context cancel function, which will stop the current HTTP request.
+func (s *Stream) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.Conn.Stop()
+}
+
+// Restart stops the stream and connects it to the RLP Gateway again. The monitor and
+// source refreshes restart streams concurrently, a restart waits for the one running so
+// a single connection is left.
+func (s *Stream) Restart() {
+	s.restarting.Lock()
+	defer s.restarting.Unlock()
+	s.Stop()
+	atomic.AddInt64(&s.Reconnects, 1)
+	if s.firehose.backfill != nil {
+		s.firehose.backfill.Reconnected()
+	}
+	s.Start()
+}
+
+// Idle returns how long it has been since the stream last received envelopes.
+func (s *Stream) Idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&s.lastEvent)))
+}
+
+// GetEventCount ...
+func (s *Stream) GetEventCount() int64 {
+	return atomic.LoadInt64(&s.EventCount)
+}
+
+// GetReconnects ...
+func (s *Stream) GetReconnects() int64 {
+	return atomic.LoadInt64(&s.Reconnects)
+}
+
+// ResetEventCount ...
+func (s *Stream) ResetEventCount() {
+	atomic.StoreInt64(&s.EventCount, 0)
+}
+
+// stateChanged logs connection state transitions, errors at warning level.
+func (s *Stream) stateChanged(from, to State, err *ConnectionError) {
+	if err != nil {
+		s.firehose.log.Warnf("stream %d %s after %s", s.ID, to, err.Error())
+		return
+	}
+	s.firehose.log.Debugf("stream %d %s", s.ID, to)
+}
+
+func (s *Stream) touch() {
+	atomic.StoreInt64(&s.lastEvent, time.Now().UnixNano())
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package syslog receives CF syslog drains (RFC 5424 over TCP or TLS) and converts
+// each message to a v2 envelope, so drained apps are processed like RLP Gateway envelopes.
+package syslog
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/logger"
+)
+
+// Queue receives the converted envelopes, satisfied by firehose.OneToOneEnvelope.
+type Queue interface {
+	Set(*loggregator_v2.Envelope)
+}
+
+// Listener accepts syslog drain connections.
+type Listener struct {
+	EventCount  int64
+	ErrorCount  int64
+	addr        string
+	tlsConfig   *tls.Config
+	queue       Queue
+	log         *logger.Logger
+	listener    net.Listener
+	connections map[net.Conn]struct{}
+	sync        *sync.Mutex
+}
+
+// New Listener on addr, using TLS when tlsConfig is not nil.
+func New(addr string, tlsConfig *tls.Config, q Queue, log *logger.Logger) *Listener {
+	return &Listener{
+		addr:        addr,
+		tlsConfig:   tlsConfig,
+		queue:       q,
+		log:         log,
+		connections: map[net.Conn]struct{}{},
+		sync:        &sync.Mutex{},
+	}
+}
+
+// Start listening and accepting connections.
+func (l *Listener) Start() (err error) {
+	if l.tlsConfig != nil {
+		l.listener, err = tls.Listen("tcp", l.addr, l.tlsConfig)
+	} else {
+		l.listener, err = net.Listen("tcp", l.addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			conn, err := l.listener.Accept()
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Temporary() {
+					continue
+				}
+				l.log.Info("closed syslog listener")
+				return
+			}
+			go l.handle(conn)
+		}
+	}()
+	return nil
+}
+
+// Addr the listener is bound to.
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Close the listener and every open drain connection.
+func (l *Listener) Close() error {
+	err := l.listener.Close()
+	l.sync.Lock()
+	for conn := range l.connections {
+		conn.Close()
+	}
+	l.sync.Unlock()
+	return err
+}
+
+// GetEventCount ...
+func (l *Listener) GetEventCount() int64 {
+	return atomic.LoadInt64(&l.EventCount)
+}
+
+// ResetEventCount ...
+func (l *Listener) ResetEventCount() {
+	atomic.StoreInt64(&l.EventCount, 0)
+}
+
+func (l *Listener) handle(conn net.Conn) {
+	l.sync.Lock()
+	l.connections[conn] = struct{}{}
+	l.sync.Unlock()
+
+	defer func() {
+		conn.Close()
+		l.sync.Lock()
+		delete(l.connections, conn)
+		l.sync.Unlock()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		frame, err := ReadFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				l.log.Debugf("syslog connection from %s closed: %s", conn.RemoteAddr(), err.Error())
+			}
+			return
+		}
+		if len(frame) == 0 {
+			continue
+		}
+		m, err := Parse(frame)
+		if err != nil {
+			atomic.AddInt64(&l.ErrorCount, 1)
+			l.log.Debugf("invalid syslog message from %s: %s", conn.RemoteAddr(), err.Error())
+			continue
+		}
+		l.queue.Set(m.Envelope())
+		atomic.AddInt64(&l.EventCount, 1)
+		l.log.Tracer("<")
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// CF syslog drains identify their structured data elements with this enterprise number.
+const enterpriseID = "@47450"
+
+// maxMessageSize bounds octet counted frames.
+const maxMessageSize = 1024 * 1024
+
+// Message is a parsed RFC 5424 syslog message.
+type Message struct {
+	Priority       int
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData []Element
+	Msg            []byte
+}
+
+// Element is a structured data element, for example [tags@47450 source_type="APP/PROC/WEB"].
+type Element struct {
+	ID     string
+	Params map[string]string
+}
+
+// ReadFrame reads one message from a syslog TCP stream. Octet counted framing
+// (RFC 6587 3.4.1) is used when the frame starts with a digit, otherwise the
+// message is terminated by a newline.
+func ReadFrame(r *bufio.Reader) ([]byte, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] < '0' || b[0] > '9' {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) > 0 {
+			err = nil
+		}
+		return bytes.TrimRight(line, "\r\n"), err
+	}
+
+	length, err := r.ReadString(' ')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(length))
+	if err != nil {
+		return nil, fmt.Errorf("invalid octet count %q", length)
+	}
+	if n > maxMessageSize {
+		return nil, fmt.Errorf("octet count %d exceeds %d", n, maxMessageSize)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Parse decodes an RFC 5424 syslog message:
+// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func Parse(data []byte) (*Message, error) {
+	if len(data) == 0 || data[0] != '<' {
+		return nil, errors.New("missing priority")
+	}
+	end := bytes.IndexByte(data, '>')
+	if end < 2 || end > 4 {
+		return nil, errors.New("invalid priority")
+	}
+	pri, err := strconv.Atoi(string(data[1:end]))
+	if err != nil || pri > 191 {
+		return nil, errors.New("invalid priority")
+	}
+
+	m := &Message{Priority: pri}
+	rest := data[end+1:]
+
+	fields := make([]string, 6)
+	for i := range fields {
+		sp := bytes.IndexByte(rest, ' ')
+		if sp < 0 {
+			return nil, errors.New("truncated header")
+		}
+		fields[i] = string(rest[:sp])
+		rest = rest[sp+1:]
+	}
+	if fields[0] != "1" {
+		return nil, fmt.Errorf("unsupported version %q", fields[0])
+	}
+	if fields[1] != "-" {
+		if m.Timestamp, err = time.Parse(time.RFC3339Nano, fields[1]); err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+	}
+	m.Hostname = nilValue(fields[2])
+	m.AppName = nilValue(fields[3])
+	m.ProcID = nilValue(fields[4])
+	m.MsgID = nilValue(fields[5])
+
+	if m.StructuredData, rest, err = parseStructuredData(rest); err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 && rest[0] == ' ' {
+		rest = rest[1:]
+	}
+	// Drop the UTF-8 byte order mark and the trailing newline CF adds to each message.
+	rest = bytes.TrimPrefix(rest, []byte("\xef\xbb\xbf"))
+	m.Msg = bytes.TrimRight(rest, "\r\n")
+	return m, nil
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+func parseStructuredData(data []byte) ([]Element, []byte, error) {
+	if len(data) > 0 && data[0] == '-' {
+		return nil, data[1:], nil
+	}
+
+	var elements []Element
+	for len(data) > 0 && data[0] == '[' {
+		data = data[1:]
+		end := bytes.IndexAny(data, " ]")
+		if end < 0 {
+			return nil, nil, errors.New("unterminated structured data")
+		}
+		el := Element{ID: string(data[:end]), Params: map[string]string{}}
+		data = data[end:]
+
+		for len(data) > 0 && data[0] == ' ' {
+			data = data[1:]
+			eq := bytes.IndexByte(data, '=')
+			if eq < 0 || len(data) < eq+2 || data[eq+1] != '"' {
+				return nil, nil, fmt.Errorf("invalid parameter in %s", el.ID)
+			}
+			name := string(data[:eq])
+			data = data[eq+2:]
+
+			var value []byte
+			closed := false
+			for i := 0; i < len(data); i++ {
+				if data[i] == '\\' && i+1 < len(data) && (data[i+1] == '"' || data[i+1] == '\\' || data[i+1] == ']') {
+					value = append(value, data[i+1])
+					i++
+					continue
+				}
+				if data[i] == '"' {
+					data = data[i+1:]
+					closed = true
+					break
+				}
+				value = append(value, data[i])
+			}
+			if !closed {
+				return nil, nil, fmt.Errorf("unterminated parameter %s in %s", name, el.ID)
+			}
+			el.Params[name] = string(value)
+		}
+
+		if len(data) == 0 || data[0] != ']' {
+			return nil, nil, fmt.Errorf("unterminated structured data %s", el.ID)
+		}
+		data = data[1:]
+		elements = append(elements, el)
+	}
+	if len(elements) == 0 {
+		return nil, nil, errors.New("missing structured data")
+	}
+	return elements, data, nil
+}
+
+// Envelope converts a CF formatted syslog message to a v2 envelope. The app GUID is
+// the APP-NAME, PROCID holds the source type and instance, for example
+// [APP/PROC/WEB/0], and the tags@47450 element carries the envelope tags. Messages with
+// gauge@47450 or counter@47450 elements become Gauge or Counter envelopes, everything
+// else is a Log envelope.
+func (m *Message) Envelope() *loggregator_v2.Envelope {
+	e := &loggregator_v2.Envelope{
+		Timestamp: m.Timestamp.UnixNano(),
+		SourceId:  m.AppName,
+		Tags:      map[string]string{},
+	}
+	if m.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UnixNano()
+	}
+
+	procID := strings.Trim(m.ProcID, "[]")
+	sourceType := procID
+	if i := strings.LastIndex(procID, "/"); i > 0 {
+		if _, err := strconv.Atoi(procID[i+1:]); err == nil {
+			sourceType = procID[:i]
+			e.InstanceId = procID[i+1:]
+		}
+	}
+
+	var gauge *loggregator_v2.Gauge
+	for _, el := range m.StructuredData {
+		switch el.ID {
+		case "tags" + enterpriseID:
+			for k, v := range el.Params {
+				e.Tags[k] = v
+			}
+		case "gauge" + enterpriseID:
+			if gauge == nil {
+				gauge = &loggregator_v2.Gauge{Metrics: map[string]*loggregator_v2.GaugeValue{}}
+			}
+			value, _ := strconv.ParseFloat(el.Params["value"], 64)
+			gauge.Metrics[el.Params["name"]] = &loggregator_v2.GaugeValue{
+				Unit:  el.Params["unit"],
+				Value: value,
+			}
+		case "counter" + enterpriseID:
+			total, _ := strconv.ParseUint(el.Params["total"], 10, 64)
+			delta, _ := strconv.ParseUint(el.Params["delta"], 10, 64)
+			e.Message = &loggregator_v2.Envelope_Counter{Counter: &loggregator_v2.Counter{
+				Name:  el.Params["name"],
+				Total: total,
+				Delta: delta,
+			}}
+		}
+	}
+	if _, ok := e.Tags["source_type"]; !ok && sourceType != "" {
+		e.Tags["source_type"] = sourceType
+	}
+
+	if gauge != nil {
+		e.Message = &loggregator_v2.Envelope_Gauge{Gauge: gauge}
+	}
+	if e.Message == nil {
+		logType := loggregator_v2.Log_OUT
+		// Severity 3 (error) is used for STDERR
+		if m.Priority&7 == 3 {
+			logType = loggregator_v2.Log_ERR
+		}
+		e.Message = &loggregator_v2.Envelope_Log{Log: &loggregator_v2.Log{
+			Payload: m.Msg,
+			Type:    logType,
+		}}
+	}
+	return e
+}
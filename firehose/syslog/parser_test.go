@@ -0,0 +1,83 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package syslog
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/stretchr/testify/assert"
+)
+
+const logLine = `<14>1 2020-02-09T22:47:04.917459+00:00 org.space.app c70684e2-4443-4ed5-8dc8-28b7cf7d97ed [APP/PROC/WEB/1] - [tags@47450 app_name="app" source_type="APP/PROC/WEB" origin="rep" job="diego-cell"] hello "world"` + "\n"
+
+func TestParseLog(t *testing.T) {
+	m, err := Parse([]byte(logLine))
+	assert.NoError(t, err)
+	assert.Equal(t, "org.space.app", m.Hostname)
+
+	e := m.Envelope()
+	assert.Equal(t, "c70684e2-4443-4ed5-8dc8-28b7cf7d97ed", e.GetSourceId())
+	assert.Equal(t, "1", e.GetInstanceId())
+	assert.Equal(t, int64(1581288424917459000), e.GetTimestamp())
+	assert.Equal(t, "APP/PROC/WEB", e.Tags["source_type"])
+	assert.Equal(t, "diego-cell", e.Tags["job"])
+	assert.Equal(t, `hello "world"`, string(e.GetLog().GetPayload()))
+	assert.Equal(t, loggregator_v2.Log_OUT, e.GetLog().GetType())
+}
+
+func TestParseErrorLogWithoutTags(t *testing.T) {
+	m, err := Parse([]byte(`<11>1 2020-02-09T22:47:04Z host guid [STG/0] - - failed`))
+	assert.NoError(t, err)
+	e := m.Envelope()
+	assert.Equal(t, "STG", e.Tags["source_type"])
+	assert.Equal(t, loggregator_v2.Log_ERR, e.GetLog().GetType())
+	assert.Equal(t, "failed", string(e.GetLog().GetPayload()))
+}
+
+func TestParseGaugeAndCounter(t *testing.T) {
+	m, err := Parse([]byte(`<14>1 2020-02-09T22:47:04Z host guid [APP/PROC/WEB/0] - [gauge@47450 name="cpu" value="0.5" unit="percentage"][gauge@47450 name="memory" value="1024" unit="bytes"][tags@47450 origin="rep"]`))
+	assert.NoError(t, err)
+	g := m.Envelope().GetGauge()
+	assert.Equal(t, 0.5, g.GetMetrics()["cpu"].GetValue())
+	assert.Equal(t, "bytes", g.GetMetrics()["memory"].GetUnit())
+
+	m, err = Parse([]byte(`<14>1 2020-02-09T22:47:04Z host guid [APP/PROC/WEB/0] - [counter@47450 name="requests" total="10" delta="2"]`))
+	assert.NoError(t, err)
+	c := m.Envelope().GetCounter()
+	assert.Equal(t, "requests", c.GetName())
+	assert.Equal(t, uint64(10), c.GetTotal())
+	assert.Equal(t, uint64(2), c.GetDelta())
+}
+
+func TestParseEscapedParams(t *testing.T) {
+	m, err := Parse([]byte(`<14>1 - host guid - - [tags@47450 a="x\"y\]z"] msg`))
+	assert.NoError(t, err)
+	assert.Equal(t, `x"y]z`, m.StructuredData[0].Params["a"])
+	assert.Equal(t, "msg", string(m.Msg))
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, msg := range []string{
+		"",
+		"no priority",
+		"<14>2 - host guid - - - msg",
+		"<14>1 - host",
+		`<14>1 - host guid - - [tags@47450 a="x`,
+	} {
+		_, err := Parse([]byte(msg))
+		assert.Error(t, err, msg)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("5 <14>1" + "plain line\n" + "3 abc"))
+	for _, want := range []string{"<14>1", "plain line", "abc"} {
+		frame, err := ReadFrame(r)
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(frame))
+	}
+}
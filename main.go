@@ -4,6 +4,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
@@ -20,6 +21,12 @@ var Version string
 func main() {
 
 	version()
+
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replay(os.Args[2:])
+		return
+	}
+
 	interupt := make(chan os.Signal, 1)
 	signal.Notify(interupt, os.Interrupt, os.Kill, syscall.SIGTERM)
 	newrelic.Start(interupt)
@@ -41,3 +48,24 @@ func version() {
 		os.Exit(0)
 	}
 }
+
+// replay feeds a recorded envelope file through the nozzle:
+// nr-fh-nozzle replay -file fhout.json -speed 10 -sink stdout
+func replay(args []string) {
+	o := newrelic.ReplayOptions{}
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.StringVar(&o.File, "file", "", "envelope recording to replay (required)")
+	fs.Float64Var(&o.Speed, "speed", 1, "multiple of the original envelope rate, 0 replays as fast as possible")
+	fs.StringVar(&o.Sink, "sink", "stdout", "newrelic, stdout, or a file path to write JSON events to")
+	fs.Parse(args)
+
+	if o.File == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := newrelic.Replay(o); err != nil {
+		fmt.Fprintf(os.Stderr, "replay failed: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
@@ -21,23 +21,41 @@ type EnvelopeTypes []string
 
 // Interface ensures Firehose Envelope consumption
 type Interface interface {
-	New() Interface
+	New(*config.Config) Interface
 	Update(*loggregator_v2.Envelope)
 	Streams() []string
 	HarvestMetrics(*entities.Entity, *metrics.Metric)
 	Drain() []*entities.Entity
 }
 
+// Flusher is implemented by accumulators holding envelopes back, Flush processes them
+// when the nozzle closes.
+type Flusher interface {
+	Flush()
+}
+
+// PercentileProvider is implemented by accumulators embedding Accumulator, the harvester
+// sets their Percentiles on Distribution metrics before HarvestMetrics.
+type PercentileProvider interface {
+	Percentiles() []float64
+}
+
 // Accumulator Universal handler for Firehose Envelopes
 type Accumulator struct {
 	Entities      *entities.Map
 	EnvelopeTypes []string
 	ctx           *app.Application
+	config        *config.Config
+	cardinality   *cardinality
+	percentiles   []float64
 }
 
-// NewAccumulator is generic and requires .Interface to be set
+// NewAccumulator is generic and requires .Interface to be set. c holds the settings
+// of the accumulator instance, see registry.New.
 // func NewAccumulator(t ...events.Envelope_EventType) Accumulator {
-func NewAccumulator(t ...string) Accumulator {
+func NewAccumulator(c *config.Config, t ...string) Accumulator {
+	// Invalid percentiles are rejected by registry.New.
+	percentiles, _ := c.GetPercentiles()
 	types := make(EnvelopeTypes, len(t))
 	for _, envelopType := range t {
 		types = append(types, envelopType)
@@ -46,15 +64,25 @@ func NewAccumulator(t ...string) Accumulator {
 		Entities:      entities.NewMap(),
 		EnvelopeTypes: types,
 		ctx:           app.Get(),
+		config:        c,
+		cardinality:   newCardinality(c),
+		percentiles:   percentiles,
 	}
 }
 
+// Percentiles of the METRICS_PERCENTILES setting of the accumulator instance, emitted by
+// its Distribution metrics, see PercentileProvider.
+func (a Accumulator) Percentiles() []float64 {
+	return a.percentiles
+}
+
 // Config properties ...
 func (a *Accumulator) Config() *config.Config {
-	return a.ctx.Config
+	return a.config
 }
 
-// GetEntity ...
+// GetEntity of the attributes, the entities.Overflow entity when the envelope source is
+// over the CARDINALITY_MAX_* limits.
 func (a *Accumulator) GetEntity(
 	e *loggregator_v2.Envelope,
 	attrs *attributes.Attributes,
@@ -62,13 +90,35 @@ func (a *Accumulator) GetEntity(
 	if e, found := a.Entities.Has(attrs.Signature()); found {
 		return e
 	}
+	if a.cardinality != nil {
+		return a.cardinality.getEntity(a.Entities, e.GetSourceId(), attrs)
+	}
 	entity := entities.NewEntity(attrs)
 	a.Entities.Put(entity)
 	return entity
 }
 
+// GetEventEntity of the attributes of an envelope sent as an event or log entry. Events
+// carry the attributes of their entity and hold no metrics, so the CARDINALITY_MAX_*
+// limits don't apply and the entity is only kept when there are none, as by GetEntity.
+func (a *Accumulator) GetEventEntity(
+	e *loggregator_v2.Envelope,
+	attrs *attributes.Attributes,
+) *entities.Entity {
+	if a.cardinality == nil {
+		return a.GetEntity(e, attrs)
+	}
+	if entity, found := a.Entities.Has(attrs.Signature()); found {
+		return entity
+	}
+	return entities.NewEntity(attrs)
+}
+
 // Drain ...
 func (a Accumulator) Drain() []*entities.Entity {
+	if a.cardinality != nil {
+		return a.cardinality.drain(a.Entities, a.config)
+	}
 	return a.Entities.Drain()
 }
 
@@ -0,0 +1,183 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package accumulators
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/entities"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrclients"
+)
+
+// cardinality limits the entities and metrics an accumulator holds between harvests, in
+// total and per source. Samples over the limits go to the entities.Overflow entity, and the
+// sources that tripped a limit are reported when the accumulator is drained.
+type cardinality struct {
+	maxEntities       int
+	maxMetrics        int
+	maxSourceEntities int
+	maxSourceMetrics  int
+	lock              *sync.Mutex
+	entities          int
+	metrics           int
+	sources           map[string]*sourceCardinality
+}
+
+type sourceCardinality struct {
+	entities         int
+	metrics          int
+	overflowEntities int
+	overflowMetrics  int
+}
+
+// overflowAttributes of the entities.Overflow entity.
+func overflowAttributes() *attributes.Attributes {
+	return attributes.NewAttributes(attributes.New("cardinality.entity", entities.Overflow))
+}
+
+// newCardinality from CARDINALITY_MAX_*, nil when there are no limits.
+func newCardinality(c *config.Config) *cardinality {
+	l := &cardinality{
+		maxEntities:       c.GetInt("CARDINALITY_MAX_ENTITIES"),
+		maxMetrics:        c.GetInt("CARDINALITY_MAX_METRICS"),
+		maxSourceEntities: c.GetInt("CARDINALITY_MAX_SOURCE_ENTITIES"),
+		maxSourceMetrics:  c.GetInt("CARDINALITY_MAX_SOURCE_METRICS"),
+		lock:              &sync.Mutex{},
+		sources:           map[string]*sourceCardinality{},
+	}
+	if l.maxEntities <= 0 && l.maxMetrics <= 0 && l.maxSourceEntities <= 0 && l.maxSourceMetrics <= 0 {
+		return nil
+	}
+	return l
+}
+
+func (l *cardinality) source(source string) *sourceCardinality {
+	s, found := l.sources[source]
+	if !found {
+		s = &sourceCardinality{}
+		l.sources[source] = s
+	}
+	return s
+}
+
+// admitEntity of the source, counting it when it is under the limits. Callers hold lock.
+func (l *cardinality) admitEntity(source string) bool {
+	s := l.source(source)
+	if (l.maxEntities > 0 && l.entities >= l.maxEntities) ||
+		(l.maxSourceEntities > 0 && s.entities >= l.maxSourceEntities) {
+		s.overflowEntities++
+		return false
+	}
+	l.entities++
+	s.entities++
+	return true
+}
+
+// admitMetric of the source, counting it when it is under the limits. The metrics of the
+// overflow entity, with the entities.Overflow source, only count toward the total.
+func (l *cardinality) admitMetric(source string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.maxMetrics > 0 && l.metrics >= l.maxMetrics {
+		if source != entities.Overflow {
+			l.source(source).overflowMetrics++
+		}
+		return false
+	}
+	if source != entities.Overflow {
+		s := l.source(source)
+		if l.maxSourceMetrics > 0 && s.metrics >= l.maxSourceMetrics {
+			s.overflowMetrics++
+			return false
+		}
+		s.metrics++
+	}
+	l.metrics++
+	return true
+}
+
+// getEntity of the attributes, a new one when the source is under the limits and the
+// overflow entity otherwise.
+func (l *cardinality) getEntity(m *entities.Map, source string, attrs *attributes.Attributes) *entities.Entity {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	// Checked again, another worker may have created the entity since.
+	if e, found := m.Has(attrs.Signature()); found {
+		return e
+	}
+	if !l.admitEntity(source) {
+		return l.overflow(m)
+	}
+	entity := entities.NewEntity(attrs)
+	entity.Limit(
+		func() bool { return l.admitMetric(source) },
+		func() *entities.Entity {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+			return l.overflow(m)
+		},
+	)
+	m.Put(entity)
+	return entity
+}
+
+// overflow entity of the map, created when first needed after each harvest. Callers
+// hold lock.
+func (l *cardinality) overflow(m *entities.Map) *entities.Entity {
+	attrs := overflowAttributes()
+	if e, found := m.Has(attrs.Signature()); found {
+		return e
+	}
+	overflow := entities.NewEntity(attrs)
+	overflow.Limit(
+		func() bool { return l.admitMetric(entities.Overflow) },
+		func() *entities.Entity { return overflow },
+	)
+	m.Put(overflow)
+	return overflow
+}
+
+// drain the map and reset the counts, reporting the sources that tripped a limit.
+func (l *cardinality) drain(m *entities.Map, c *config.Config) []*entities.Entity {
+	l.lock.Lock()
+	drained := m.Drain()
+	sources := l.sources
+	l.entities, l.metrics, l.sources = 0, 0, map[string]*sourceCardinality{}
+	l.lock.Unlock()
+
+	names := make([]string, 0, len(sources))
+	for name, s := range sources {
+		if s.overflowEntities > 0 || s.overflowMetrics > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return drained
+	}
+	sort.Strings(names)
+
+	client := nrclients.New().GetEventClient(c.GetNewRelicConfig())
+	for _, name := range names {
+		s := sources[name]
+		app.Get().Log.Warnf(
+			"cardinality limit tripped by source %s: %d entity and %d metric samples overflowed",
+			name, s.overflowEntities, s.overflowMetrics,
+		)
+		event := attributes.NewAttributes()
+		event.SetAttribute("eventType", c.GetString(config.NewRelicEventTypeCardinality))
+		event.SetAttribute("cardinality.source", name)
+		event.SetAttribute("cardinality.entities", s.entities)
+		event.SetAttribute("cardinality.metrics", s.metrics)
+		event.SetAttribute("cardinality.overflow.entities", s.overflowEntities)
+		event.SetAttribute("cardinality.overflow.metrics", s.overflowMetrics)
+		event.SetAttribute("agent.subscription", c.GetString("FIREHOSE_ID"))
+		client.EnqueueEvent(context.Background(), event.Marshal())
+	}
+	return drained
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package accumulators
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/entities"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/metrics"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrclients"
+	"github.com/stretchr/testify/assert"
+)
+
+func sample(a Accumulator, source string, entity string, metric string) {
+	e := &loggregator_v2.Envelope{SourceId: source}
+	attrs := attributes.NewAttributes(attributes.New("source", source), attributes.New("entity", entity))
+	a.GetEntity(e, attrs).NewSample(metric, metrics.Types.Gauge, "", 1).Done()
+}
+
+// metricNames of the drained entities, by entity.
+func metricNames(drained []*entities.Entity) map[string][]string {
+	names := map[string][]string{}
+	for _, e := range drained {
+		key := entities.Overflow
+		if attr := e.AttributeByName("entity"); attr != nil {
+			key = fmt.Sprint(attr.Value())
+		}
+		for _, m := range e.DrainMetrics() {
+			names[key] = append(names[key], m.Name)
+		}
+	}
+	return names
+}
+
+func TestCardinality(t *testing.T) {
+	os.Setenv("NRF_ACC_CARD_CARDINALITY_MAX_ENTITIES", "3")
+	os.Setenv("NRF_ACC_CARD_CARDINALITY_MAX_SOURCE_METRICS", "2")
+	defer os.Unsetenv("NRF_ACC_CARD_CARDINALITY_MAX_ENTITIES")
+	defer os.Unsetenv("NRF_ACC_CARD_CARDINALITY_MAX_SOURCE_METRICS")
+	a := NewAccumulator(config.Get().Scoped("card"))
+
+	sample(a, "noisy", "a", "m1")
+	sample(a, "noisy", "a", "m2")
+	sample(a, "noisy", "a", "m3")
+	sample(a, "noisy", "b", "m1")
+	sample(a, "quiet", "c", "m1")
+	sample(a, "quiet", "d", "m1")
+	sample(a, "quiet", "d", "m1")
+
+	counts := a.cardinality.sources
+	assert.Equal(t, 2, counts["noisy"].overflowMetrics)
+	assert.Equal(t, 0, counts["noisy"].overflowEntities)
+	assert.Equal(t, 2, counts["quiet"].overflowEntities)
+
+	var sink bytes.Buffer
+	nrclients.New().SetSink(nrclients.NewWriterClient(&sink))
+	defer nrclients.New().SetSink(nil)
+
+	names := metricNames(a.Drain())
+	assert.ElementsMatch(t, []string{"m1", "m2"}, names["a"])
+	assert.Nil(t, names["b"])
+	assert.ElementsMatch(t, []string{"m1"}, names["c"])
+	assert.Nil(t, names["d"])
+	// The third and fourth metrics of noisy and the samples of entity d, over the limits.
+	assert.ElementsMatch(t, []string{entities.Overflow, "m1"}, names[entities.Overflow])
+
+	nrclients.New().FlushAll()
+	var reported []map[string]interface{}
+	for d := json.NewDecoder(&sink); d.More(); {
+		var event map[string]interface{}
+		assert.NoError(t, d.Decode(&event))
+		reported = append(reported, event)
+	}
+	assert.Len(t, reported, 2)
+	assert.Equal(t, "PCFCardinalityOverflow", reported[0]["eventType"])
+	assert.Equal(t, "noisy", reported[0]["cardinality.source"])
+	assert.Equal(t, float64(2), reported[0]["cardinality.overflow.metrics"])
+	assert.Equal(t, "quiet", reported[1]["cardinality.source"])
+	assert.Equal(t, float64(2), reported[1]["cardinality.overflow.entities"])
+
+	// Limits are reset by the harvest.
+	assert.Empty(t, a.cardinality.sources)
+	sample(a, "quiet", "d", "m1")
+	assert.ElementsMatch(t, []string{"m1"}, metricNames(a.Drain())["d"])
+}
+
+func TestNoCardinalityLimits(t *testing.T) {
+	assert.Nil(t, newCardinality(config.Get().Scoped("nocard")))
+}
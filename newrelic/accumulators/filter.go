@@ -0,0 +1,127 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package accumulators
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// MetricFilter includes or excludes metrics before they are accumulated. A rule is a
+// pattern, optionally prefixed with the field it matches: name (the default), origin,
+// job or deployment. Patterns are globs where * matches any characters, or regular
+// expressions between slashes. For example:
+//
+//	origin:gorouter|job:diego_*|/^(ingress|egress)$/
+//
+// A metric must match one of the include rules, when there are any, and none of the
+// exclude rules.
+type MetricFilter struct {
+	include []rule
+	exclude []rule
+}
+
+type rule struct {
+	field   string
+	pattern *regexp.Regexp
+}
+
+// metricFields rules can match, name is the default.
+var metricFields = []string{"name", "origin", "job", "deployment"}
+
+// NewMetricFilter from the include and exclude rule lists, nil when both are empty.
+func NewMetricFilter(include string, exclude string) (*MetricFilter, error) {
+	inc, err := parseRules(include)
+	if err != nil {
+		return nil, err
+	}
+	exc, err := parseRules(exclude)
+	if err != nil {
+		return nil, err
+	}
+	if len(inc) == 0 && len(exc) == 0 {
+		return nil, nil
+	}
+	return &MetricFilter{include: inc, exclude: exc}, nil
+}
+
+// Allows reports whether the metric name of the envelope passes the filter. A nil
+// filter allows every metric.
+func (f *MetricFilter) Allows(e *loggregator_v2.Envelope, name string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 && !matchAny(f.include, e, name) {
+		return false
+	}
+	return !matchAny(f.exclude, e, name)
+}
+
+func matchAny(rules []rule, e *loggregator_v2.Envelope, name string) bool {
+	for _, r := range rules {
+		value := name
+		if r.field != "name" {
+			value = e.GetTags()[r.field]
+		}
+		if r.pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRules(s string) ([]rule, error) {
+	var rules []rule
+	for _, entry := range splitRules(s) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		field := "name"
+		for _, f := range metricFields {
+			if strings.HasPrefix(entry, f+":") {
+				field, entry = f, strings.TrimPrefix(entry, f+":")
+				break
+			}
+		}
+		expr := globExpr(entry)
+		if len(entry) > 1 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+			expr = entry[1 : len(entry)-1]
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter rule %q: %s", entry, err.Error())
+		}
+		rules = append(rules, rule{field: field, pattern: pattern})
+	}
+	return rules, nil
+}
+
+// splitRules on | or , outside of regular expressions, so /a|b/ is a single rule.
+func splitRules(s string) []string {
+	var rules []string
+	start, inRegexp := 0, false
+	for i, c := range s {
+		switch {
+		case c == '/' && (inRegexp || strings.TrimSpace(s[start:i]) == "" || strings.HasSuffix(s[start:i], ":")):
+			inRegexp = !inRegexp
+		case (c == '|' || c == ',') && !inRegexp:
+			rules = append(rules, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(rules, s[start:])
+}
+
+// globExpr anchors the glob, * matches any characters.
+func globExpr(glob string) string {
+	parts := strings.Split(glob, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return "^" + strings.Join(parts, ".*") + "$"
+}
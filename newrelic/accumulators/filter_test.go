@@ -0,0 +1,39 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package accumulators
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricFilter(t *testing.T) {
+	router := &loggregator_v2.Envelope{Tags: map[string]string{"origin": "gorouter", "job": "router", "deployment": "cf"}}
+	rep := &loggregator_v2.Envelope{Tags: map[string]string{"origin": "rep", "job": "diego_cell", "deployment": "cf"}}
+
+	f, err := NewMetricFilter("origin:gorouter|job:diego_*", "/^(ingress|egress)$/, latency.*")
+	assert.NoError(t, err)
+	assert.True(t, f.Allows(router, "total_requests"))
+	assert.True(t, f.Allows(rep, "CapacityRemainingMemory"))
+	assert.False(t, f.Allows(router, "latency.uaa"))
+	assert.False(t, f.Allows(rep, "egress"))
+	assert.False(t, f.Allows(&loggregator_v2.Envelope{Tags: map[string]string{"origin": "uaa"}}, "requests"))
+
+	f, err = NewMetricFilter("", "deployment:/^p-(mysql|redis)/")
+	assert.NoError(t, err)
+	assert.True(t, f.Allows(router, "total_requests"))
+	assert.False(t, f.Allows(&loggregator_v2.Envelope{Tags: map[string]string{"deployment": "p-redis-1"}}, "memory"))
+}
+
+func TestMetricFilterDisabled(t *testing.T) {
+	f, err := NewMetricFilter("", " ")
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+	assert.True(t, f.Allows(&loggregator_v2.Envelope{}, "anything"))
+
+	_, err = NewMetricFilter("/[/", "")
+	assert.Error(t, err)
+}
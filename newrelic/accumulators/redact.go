@@ -0,0 +1,241 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package accumulators
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/metrics"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/transform"
+)
+
+// Redaction actions.
+const (
+	RedactMask = "mask"
+	RedactHash = "hash"
+	RedactDrop = "drop"
+)
+
+// Redacted replaces masked values.
+const Redacted = "[REDACTED]"
+
+// RedactionHits is the metric counting the matches of each redaction rule, see
+// Accumulator.CountRedactions.
+const RedactionHits = "redaction.hits"
+
+// Redactor removes personal data and secrets from values before they are sent. Built-in
+// detectors are enabled by name, optionally followed by the action, for example:
+//
+//	email|card|bearer:hash|password
+//
+// User rules are a name, an optional action and a regular expression between slashes:
+//
+//	ssn:/\d{3}-\d{2}-\d{4}/|session:hash:/session=(\w+)/
+//
+// Masking replaces the match, or its first group when the expression has one, with
+// [REDACTED]. Hashing replaces it with a short hash keyed by REDACT_HASH_KEY, see
+// transform.Hash, so values can still be correlated. Dropping removes the whole attribute.
+type Redactor struct {
+	rules []*redaction
+}
+
+type redaction struct {
+	name    string
+	action  string
+	pattern *regexp.Regexp
+	valid   func(string) bool
+	key     string
+}
+
+// errNoHashKey is returned for hash rules without a key, an unkeyed hash of a value
+// such as an email is easily reversed.
+var errNoHashKey = errors.New("hash redactions need NRF_REDACT_HASH_KEY")
+
+// detectors built in, the first group of an expression is redacted when it has one.
+var detectors = map[string]*redaction{
+	"email": {
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	},
+	"card": {
+		pattern: regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`),
+		valid:   luhn,
+	},
+	"bearer": {
+		pattern: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`),
+	},
+	"password": {
+		pattern: regexp.MustCompile(`(?i)\b(?:password|passwd|pwd|secret|api[_\-]?key|access[_\-]?token)["']?\s*[:=]\s*["']?([^\s"'&,;]+)`),
+	},
+	"aws_key": {
+		pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	},
+	"url_password": {
+		pattern: regexp.MustCompile(`://[^/\s:@]+:([^/\s@]+)@`),
+	},
+}
+
+// NewRedactor from the built-in detectors and user rules, nil when both are empty. Hashed
+// matches are keyed by key.
+func NewRedactor(builtin string, rules string, key string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, entry := range splitRules(builtin) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, action := entry, RedactMask
+		if i := strings.Index(entry, ":"); i >= 0 {
+			name, action = entry[:i], entry[i+1:]
+		}
+		d, found := detectors[name]
+		if !found {
+			return nil, fmt.Errorf("unknown redaction detector %q", name)
+		}
+		if !validAction(action) {
+			return nil, fmt.Errorf("invalid redaction action %q for %q", action, name)
+		}
+		if action == RedactHash && key == "" {
+			return nil, errNoHashKey
+		}
+		r.rules = append(r.rules, &redaction{name: name, action: action, pattern: d.pattern, valid: d.valid, key: key})
+	}
+	for _, entry := range splitRules(rules) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.Index(entry, ":/")
+		if i <= 0 || len(entry) < i+3 || !strings.HasSuffix(entry, "/") {
+			return nil, fmt.Errorf("invalid redaction rule %q: want name[:action]:/regexp/", entry)
+		}
+		name, action := entry[:i], RedactMask
+		if j := strings.Index(name, ":"); j >= 0 {
+			name, action = name[:j], name[j+1:]
+		}
+		if !validAction(action) {
+			return nil, fmt.Errorf("invalid redaction action %q for %q", action, name)
+		}
+		if action == RedactHash && key == "" {
+			return nil, errNoHashKey
+		}
+		pattern, err := regexp.Compile(entry[i+2 : len(entry)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction rule %q: %s", entry, err.Error())
+		}
+		r.rules = append(r.rules, &redaction{name: name, action: action, pattern: pattern, key: key})
+	}
+	if len(r.rules) == 0 {
+		return nil, nil
+	}
+	return r, nil
+}
+
+func validAction(action string) bool {
+	return action == RedactMask || action == RedactHash || action == RedactDrop
+}
+
+// Redact the value with every rule, calling hit with the name and action of each rule that
+// matched and its number of matches. It returns the redacted value, and whether the
+// attribute holding it must be dropped instead. A nil Redactor returns the value.
+func (r *Redactor) Redact(value string, hit func(rule string, action string, count int)) (string, bool) {
+	if r == nil {
+		return value, false
+	}
+	for _, rule := range r.rules {
+		redacted, count := rule.apply(value)
+		if count == 0 {
+			continue
+		}
+		if hit != nil {
+			hit(rule.name, rule.action, count)
+		}
+		if rule.action == RedactDrop {
+			return "", true
+		}
+		value = redacted
+	}
+	return value, false
+}
+
+// CountRedactions returns the hit func of Redactor.Redact counting the matches of each rule
+// in the attribute of the app sending the envelope, harvested as the RedactionHits metric.
+func (a *Accumulator) CountRedactions(
+	e *loggregator_v2.Envelope,
+	attribute string,
+) func(rule string, action string, count int) {
+	return func(rule string, action string, count int) {
+		attrs := attributes.NewAttributes(
+			attributes.New(a.Config().AttributeName(config.EnvAppID), e.GetSourceId()),
+			attributes.New("redaction.rule", rule),
+			attributes.New("redaction.action", action),
+			attributes.New("redaction.attribute", attribute),
+		)
+		a.GetEntity(e, attrs).NewSample(
+			RedactionHits,
+			metrics.Types.Counter,
+			"matches",
+			float64(count),
+		).Done()
+	}
+}
+
+// apply the rule, returning the redacted value and the number of matches.
+func (r *redaction) apply(value string) (string, int) {
+	matches := r.pattern.FindAllStringSubmatchIndex(value, -1)
+	if len(matches) == 0 {
+		return value, 0
+	}
+	var b strings.Builder
+	count, last := 0, 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		// Only the first group is redacted when the expression has one.
+		if len(m) > 2 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		if r.valid != nil && !r.valid(value[start:end]) {
+			continue
+		}
+		count++
+		b.WriteString(value[last:start])
+		b.WriteString(r.replacement(value[start:end]))
+		last = end
+	}
+	b.WriteString(value[last:])
+	return b.String(), count
+}
+
+func (r *redaction) replacement(match string) string {
+	if r.action == RedactHash {
+		return "[" + transform.Hash(r.key, match) + "]"
+	}
+	return Redacted
+}
+
+// luhn reports whether the digits of the card number have a valid check digit.
+func luhn(number string) bool {
+	sum, digits := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
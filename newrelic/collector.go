@@ -13,13 +13,13 @@ type Collector struct {
 	accumulators *registry.Accumulators
 }
 
-// NewCollector ...
+// NewCollector holding the accumulator instances created by registry.New
 func NewCollector(r *registry.Accumulators) *Collector {
 	collector := Collector{
 		accumulators: &registry.Accumulators{},
 	}
 	for _, i := range *r {
-		collector.Append(i.New())
+		collector.Append(i)
 	}
 	return &collector
 }
@@ -38,3 +38,12 @@ func (c *Collector) Accumulators() *registry.Accumulators {
 func (c *Collector) Length() int {
 	return len(*c.accumulators)
 }
+
+// Flush accumulators holding envelopes back, once the router is closed.
+func (c *Collector) Flush() {
+	for _, i := range *c.accumulators {
+		if f, ok := i.(accumulators.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
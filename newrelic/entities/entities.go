@@ -13,12 +13,17 @@ import (
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/uid"
 )
 
+// Overflow names the entity and metrics holding the samples over cardinality limits.
+const Overflow = "__overflow__"
+
 // Entity ...
 type Entity struct {
 	uid        uid.ID
 	attributes *attributes.Attributes
 	metrics    *metrics.Map
 	nrevents   *nrevents.Nreventmap
+	admit      func() bool
+	overflow   func() *Entity
 }
 
 // NewEntity ...
@@ -121,6 +126,13 @@ func (e *Entity) AttributeByName(name string) *attributes.Attribute {
 	return e.Attributes().AttributeByName(name)
 }
 
+// Limit the metrics of the entity: a new metric is only created when admit returns true,
+// the samples of the others are added to the Overflow metrics of the overflow entity.
+func (e *Entity) Limit(admit func() bool, overflow func() *Entity) {
+	e.admit = admit
+	e.overflow = overflow
+}
+
 // MetricCount ...
 func (e *Entity) MetricCount() int {
 	return e.metrics.Count()
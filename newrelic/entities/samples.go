@@ -4,6 +4,7 @@
 package entities
 
 import (
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/metrics"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/metrics/samples"
 )
@@ -26,6 +27,13 @@ func (s *Sample) Done() *metrics.Metric {
 		metric.Update(s.sample.Value())
 		return metric
 	}
+	if name, t, unit, _ := s.sample.Signature(); name != Overflow && s.entity.admit != nil && !s.entity.admit() {
+		overflow := &Sample{
+			entity: s.entity.overflow(),
+			sample: samples.NewSample(Overflow, t, unit, s.sample.Value(), attributes.NewAttributes()),
+		}
+		return overflow.Done()
+	}
 	metric := s.sample.NewMetric()
 	s.entity.PutMetric(metric)
 	return metric
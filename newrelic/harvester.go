@@ -31,8 +31,15 @@ func (h *Harvester) Accumulators() []accumulators.Interface {
 func (h *Harvester) Harvest() {
 	app.Get().Log.Debug("\nHarvest...")
 	for _, accumulator := range h.Accumulators() {
+		var percentiles []float64
+		if p, ok := accumulator.(accumulators.PercentileProvider); ok {
+			percentiles = p.Percentiles()
+		}
 		for _, entity := range accumulator.Drain() {
 			for _, metric := range entity.DrainMetrics() {
+				if metric.Sketch() != nil {
+					metric.SetPercentiles("metric", percentiles)
+				}
 				accumulator.HarvestMetrics(entity, metric)
 			}
 		}
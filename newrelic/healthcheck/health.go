@@ -6,10 +6,28 @@ package healthcheck
 import (
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
 )
 
+// Checker reports the health of a component, with a status line for each of its parts.
+type Checker interface {
+	Health() (status []string, healthy bool)
+}
+
+var (
+	checkers []Checker
+	lock     = &sync.RWMutex{}
+)
+
+// Register adds a Checker to the /health response.
+func Register(c Checker) {
+	lock.Lock()
+	checkers = append(checkers, c)
+	lock.Unlock()
+}
+
 // Start creates a HTTP server that listens and responds to /health requests
 func Start() {
 	go func() {
@@ -18,7 +36,26 @@ func Start() {
 	}()
 }
 
-// healthCheckHandler defines the response for requests to /health endpoint
+// healthCheckHandler defines the response for requests to /health endpoint.
+// Any unhealthy Checker fails the request with 503.
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "I'm alive and well!")
+	lock.RLock()
+	defer lock.RUnlock()
+
+	healthy := true
+	var lines []string
+	for _, c := range checkers {
+		status, ok := c.Health()
+		healthy = healthy && ok
+		lines = append(lines, status...)
+	}
+
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		fmt.Fprintln(w, "I'm alive and well!")
+	}
+	for _, l := range lines {
+		fmt.Fprintln(w, l)
+	}
 }
@@ -3,7 +3,10 @@
 
 package metrics
 
-import "reflect"
+import (
+	"reflect"
+	"strconv"
+)
 
 // JSONDefaults for JSONMap
 var JSONDefaults JSONMap
@@ -69,6 +72,16 @@ func (m *Metric) Marshal() (r *map[string]interface{}) {
 		}
 	}
 
+	if m.sketch != nil {
+		prefix := m.percentilePrefix
+		if prefix == "" {
+			prefix = "metric"
+		}
+		for _, p := range m.percentiles {
+			payload[prefix+".p"+strconv.FormatFloat(p, 'f', -1, 64)] = m.sketch.Quantile(p / 100)
+		}
+	}
+
 	for k, v := range m.Attributes().Marshal() {
 		payload[k] = v
 	}
@@ -27,6 +27,10 @@ type Metric struct {
 	Aliases    *attributes.Attributes
 	mapSync    *sync.RWMutex
 	sender     func(*Metric)
+	sketch     *Sketch
+
+	percentiles      []float64
+	percentilePrefix string
 }
 
 // New ...
@@ -49,6 +53,10 @@ func New(
 		Samples:    1,
 		Aliases:    attributes.NewAttributes(),
 	}
+	if t == distribution {
+		m.sketch = NewSketch()
+		m.sketch.Add(value)
+	}
 	return m
 }
 
@@ -74,6 +82,9 @@ func (m *Metric) Update(v float64) *Metric {
 		m.Max = v
 	}
 	m.Samples++
+	if m.sketch != nil {
+		m.sketch.Add(v)
+	}
 	m.Unlock()
 	return m
 }
@@ -83,6 +94,20 @@ func (m *Metric) Type() Type {
 	return m.T
 }
 
+// Sketch of the samples of a Distribution metric, nil for other types.
+func (m *Metric) Sketch() *Sketch {
+	return m.sketch
+}
+
+// SetPercentiles marshaled for a Distribution metric as <prefix>.p<percentile>, the
+// prefix defaults to metric.
+func (m *Metric) SetPercentiles(prefix string, percentiles []float64) *Metric {
+	m.Lock()
+	m.percentilePrefix, m.percentiles = prefix, percentiles
+	m.Unlock()
+	return m
+}
+
 // SetAttribute ...
 func (m *Metric) SetAttribute(name string, value interface{}) *Metric {
 	m.Lock()
@@ -0,0 +1,134 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"math"
+	"sort"
+)
+
+// SketchAccuracy is the relative accuracy of the quantiles of a Sketch.
+const SketchAccuracy = 0.01
+
+// Sketch is a mergeable DDSketch. Samples are counted in logarithmic bins so every
+// quantile is within SketchAccuracy of the exact value, the number of bins only grows
+// with the range of the samples, about 1,000 bins cover nanoseconds to hours.
+type Sketch struct {
+	gamma    float64
+	logGamma float64
+	positive map[int]uint64
+	negative map[int]uint64
+	zero     uint64
+	count    uint64
+	min      float64
+	max      float64
+}
+
+// NewSketch ...
+func NewSketch() *Sketch {
+	gamma := (1 + SketchAccuracy) / (1 - SketchAccuracy)
+	return &Sketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		positive: map[int]uint64{},
+		negative: map[int]uint64{},
+		min:      math.Inf(1),
+		max:      math.Inf(-1),
+	}
+}
+
+// Add a sample.
+func (s *Sketch) Add(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	switch {
+	case v > 0:
+		s.positive[s.key(v)]++
+	case v < 0:
+		s.negative[s.key(-v)]++
+	default:
+		s.zero++
+	}
+	s.count++
+	s.min = math.Min(s.min, v)
+	s.max = math.Max(s.max, v)
+}
+
+// Merge the samples of o into s.
+func (s *Sketch) Merge(o *Sketch) {
+	for k, n := range o.positive {
+		s.positive[k] += n
+	}
+	for k, n := range o.negative {
+		s.negative[k] += n
+	}
+	s.zero += o.zero
+	s.count += o.count
+	s.min = math.Min(s.min, o.min)
+	s.max = math.Max(s.max, o.max)
+}
+
+// Count of samples.
+func (s *Sketch) Count() uint64 {
+	return s.count
+}
+
+// Quantile returns the value at q, between 0 and 1, or 0 when the sketch is empty.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+	rank := uint64(q * float64(s.count-1))
+
+	// Negative bins from the largest magnitude, then zero, then positive bins.
+	var seen uint64
+	for _, k := range sortedKeys(s.negative, true) {
+		if seen += s.negative[k]; seen > rank {
+			return s.clamp(-s.value(k))
+		}
+	}
+	if seen += s.zero; seen > rank {
+		return 0
+	}
+	for _, k := range sortedKeys(s.positive, false) {
+		if seen += s.positive[k]; seen > rank {
+			return s.clamp(s.value(k))
+		}
+	}
+	return s.max
+}
+
+// key of the bin holding v, v > 0.
+func (s *Sketch) key(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// value representing the bin k, within the relative accuracy of every sample in it.
+func (s *Sketch) value(k int) float64 {
+	return 2 * math.Pow(s.gamma, float64(k)) / (s.gamma + 1)
+}
+
+func (s *Sketch) clamp(v float64) float64 {
+	return math.Max(s.min, math.Min(s.max, v))
+}
+
+func sortedKeys(bins map[int]uint64, descending bool) []int {
+	keys := make([]int, 0, len(bins))
+	for k := range bins {
+		keys = append(keys, k)
+	}
+	if descending {
+		sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	} else {
+		sort.Ints(keys)
+	}
+	return keys
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSketchQuantiles(t *testing.T) {
+	s := NewSketch()
+	values := make([]float64, 10000)
+	for i := range values {
+		values[i] = rand.ExpFloat64() * 100
+		s.Add(values[i])
+	}
+	sort.Float64s(values)
+
+	for _, q := range []float64{0.5, 0.9, 0.95, 0.99} {
+		exact := values[int(q*float64(len(values)-1))]
+		assert.InEpsilon(t, exact, s.Quantile(q), SketchAccuracy*1.01)
+	}
+	assert.Equal(t, values[0], s.Quantile(0))
+	assert.Equal(t, values[len(values)-1], s.Quantile(1))
+}
+
+func TestSketchMerge(t *testing.T) {
+	a, b := NewSketch(), NewSketch()
+	for i := 1; i <= 50; i++ {
+		a.Add(float64(i))
+		b.Add(float64(-i))
+	}
+	b.Add(0)
+	a.Merge(b)
+	assert.Equal(t, uint64(101), a.Count())
+	assert.Equal(t, float64(-50), a.Quantile(0))
+	assert.Equal(t, float64(0), a.Quantile(0.5))
+	assert.InEpsilon(t, 25, a.Quantile(0.75), SketchAccuracy)
+	assert.Equal(t, float64(50), a.Quantile(1))
+}
+
+func TestDistributionMarshal(t *testing.T) {
+	m := New("app.cpu", Types.Distribution, "percent", 1, attributes.NewAttributes())
+	for i := 2; i <= 100; i++ {
+		m.Update(float64(i))
+	}
+	assert.NotContains(t, *m.Marshal(), "metric.p50")
+
+	payload := *m.SetPercentiles("", []float64{50, 99}).Marshal()
+	assert.Equal(t, "Distribution", payload["metric.type"])
+	assert.InEpsilon(t, 50, payload["metric.p50"], SketchAccuracy)
+	assert.InEpsilon(t, 99, payload["metric.p99"], SketchAccuracy)
+	assert.NotContains(t, payload, "metric.p90")
+
+	payload = *m.SetPercentiles("http.duration", []float64{99.5}).Marshal()
+	assert.InEpsilon(t, 99.5, payload["http.duration.p99.5"], 2*SketchAccuracy)
+	assert.NotContains(t, payload, "metric.p99")
+
+	g := New("app.cpu", Types.Gauge, "percent", 1, attributes.NewAttributes())
+	assert.Nil(t, g.Sketch())
+	assert.NotContains(t, *g.Marshal(), "metric.p50")
+}
@@ -16,6 +16,8 @@ func (m Type) String() string {
 		return "Counter"
 	case delta:
 		return "Delta"
+	case distribution:
+		return "Distribution"
 	}
 	return "unset"
 }
@@ -25,6 +27,7 @@ const (
 	count
 	counter
 	delta
+	distribution
 )
 
 // MetricTypes ...
@@ -33,6 +36,8 @@ type metricTypes struct {
 	Counter Type
 	Gauge   Type
 	Delta   Type
+	// Distribution keeps a Sketch of the samples, the marshaler emits its Percentiles.
+	Distribution Type
 }
 
 // Types ...
@@ -41,4 +46,6 @@ var Types = metricTypes{
 	Counter: counter,
 	Gauge:   gauge,
 	Delta:   delta,
+
+	Distribution: distribution,
 }
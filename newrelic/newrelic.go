@@ -11,7 +11,9 @@ import (
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/cfapps"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/firehose"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/healthcheck"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrclients"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/registry"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/transform"
 )
 
 // NewRelic Object
@@ -32,18 +34,30 @@ type NewRelic struct {
 func Start(interupt <-chan os.Signal) {
 
 	app := app.Get()
+	app.Config.Validate()
 
 	nr := &NewRelic{
 		App:          app,
 		CFAppManager: cfapps.Start(app),
 		Harvest:      harvestConfig(app),
-		Collector:    NewCollector(registry.Register),
 	}
 
+	pipeline, err := transform.New(app.Config.GetString("TRANSFORM_RULES"), app.Config.GetString("REDACT_HASH_KEY"))
+	if err != nil {
+		app.Log.Fatalf("invalid TRANSFORM_RULES: %s", err.Error())
+	}
+	nrclients.New().SetPipeline(pipeline)
+	accumulators, err := registry.New(app.Config)
+	if err != nil {
+		app.Log.Fatalf("invalid accumulator configuration: %s", err.Error())
+	}
+	nr.Collector = NewCollector(accumulators)
+
 	nr.Firehose = firehose.Start()
-	nr.Router = NewRouter(nr.Firehose, nr.Collector)
+	nr.Router = NewRouter(nr.Firehose.Queue, nr.Collector)
 	nr.Router.Start()
 	nr.Harvester = NewHarvester(nr.Collector)
+	healthcheck.Register(nr.Firehose)
 	healthcheck.Start()
 
 	for {
@@ -54,6 +68,8 @@ func Start(interupt <-chan os.Signal) {
 			nr.Firehose.Close()
 			nr.Router.Close()
 			app.WaitGroup.Wait()
+			nr.Collector.Flush()
+			nrclients.New().FlushAll()
 			app.Log.Info("closed New Relic")
 			return
 
@@ -61,6 +77,9 @@ func Start(interupt <-chan os.Signal) {
 			app.Log.Error(err)
 
 		case <-nr.Harvest.C:
+			for _, s := range nr.Firehose.StreamStats() {
+				app.Log.Debug(s)
+			}
 			nr.Firehose.ResetEventCount()
 			nr.Harvester.Harvest()
 		}
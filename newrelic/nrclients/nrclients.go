@@ -15,6 +15,7 @@ import (
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/attributes"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/transform"
 
 	clientConfig "github.com/newrelic/newrelic-client-go/pkg/config"
 	"github.com/newrelic/newrelic-client-go/pkg/events"
@@ -26,12 +27,24 @@ var once sync.Once
 var instance *ClientManager
 var cfg = app.Get().Config
 
+// EventClient is the part of the New Relic Event API client used by accumulators.
+type EventClient interface {
+	EnqueueEvent(context.Context, interface{}) error
+	Flush() error
+}
+
+// LogClient is the part of the New Relic Logs API client used by accumulators.
+type LogClient interface {
+	EnqueueLogEntry(context.Context, interface{}) error
+	Flush() error
+}
+
 // New ...
 func New() *ClientManager {
 	once.Do(func() {
 		instance = &ClientManager{
-			eCollection: map[string]*events.Events{},
-			lCollection: map[string]*logs.Logs{},
+			eCollection: map[string]EventClient{},
+			lCollection: map[string]LogClient{},
 			sync:        &sync.RWMutex{},
 		}
 	})
@@ -40,13 +53,29 @@ func New() *ClientManager {
 
 // ClientManager ...
 type ClientManager struct {
-	eCollection map[string]*events.Events
-	lCollection map[string]*logs.Logs
+	eCollection map[string]EventClient
+	lCollection map[string]LogClient
+	sink        *WriterClient
+	pipeline    *transform.Pipeline
 	sync        *sync.RWMutex
 }
 
+// SetSink sends every event and log entry to the writer client instead of New Relic.
+func (cm *ClientManager) SetSink(c *WriterClient) {
+	cm.sync.Lock()
+	cm.sink = c
+	cm.sync.Unlock()
+}
+
+// SetPipeline transforms every event and log entry before it is enqueued.
+func (cm *ClientManager) SetPipeline(p *transform.Pipeline) {
+	cm.sync.Lock()
+	cm.pipeline = p
+	cm.sync.Unlock()
+}
+
 // HasEventClient ...
-func (cm *ClientManager) HasEventClient(insertKey string) (c *events.Events, ok bool) {
+func (cm *ClientManager) HasEventClient(insertKey string) (c EventClient, ok bool) {
 	cm.sync.RLock()
 	defer cm.sync.RUnlock()
 	c, ok = cm.eCollection[insertKey]
@@ -54,7 +83,7 @@ func (cm *ClientManager) HasEventClient(insertKey string) (c *events.Events, ok
 }
 
 // HasLogClient ...
-func (cm *ClientManager) HasLogClient(insertKey string) (c *logs.Logs, ok bool) {
+func (cm *ClientManager) HasLogClient(insertKey string) (c LogClient, ok bool) {
 	cm.sync.RLock()
 	defer cm.sync.RUnlock()
 	c, ok = cm.lCollection[insertKey]
@@ -62,14 +91,14 @@ func (cm *ClientManager) HasLogClient(insertKey string) (c *logs.Logs, ok bool)
 }
 
 // PutEventClient ...
-func (cm *ClientManager) PutEventClient(insertKey string, c *events.Events) {
+func (cm *ClientManager) PutEventClient(insertKey string, c EventClient) {
 	cm.sync.Lock()
 	cm.eCollection[insertKey] = c
 	cm.sync.Unlock()
 }
 
 // PutLogClient ...
-func (cm *ClientManager) PutLogClient(insertKey string, c *logs.Logs) {
+func (cm *ClientManager) PutLogClient(insertKey string, c LogClient) {
 	cm.sync.Lock()
 	cm.lCollection[insertKey] = c
 	cm.sync.Unlock()
@@ -227,7 +256,18 @@ func (cm *ClientManager) NewLogClient(insightsInsertKey string, rpmAccountID str
 }
 
 // GetEventClient ...
-func (cm *ClientManager) GetEventClient(insightsInsertKey string, rpmAccountID string, accountRegion string) *events.Events {
+func (cm *ClientManager) GetEventClient(insightsInsertKey string, rpmAccountID string, accountRegion string) EventClient {
+	c := cm.getEventClient(insightsInsertKey, rpmAccountID, accountRegion)
+	if p := cm.getPipeline(); p != nil {
+		return &transformedEvents{EventClient: c, pipeline: p}
+	}
+	return c
+}
+
+func (cm *ClientManager) getEventClient(insightsInsertKey string, rpmAccountID string, accountRegion string) EventClient {
+	if c := cm.getSink(); c != nil {
+		return c
+	}
 	if c, ok := cm.HasEventClient(insightsInsertKey); ok {
 		return c
 	}
@@ -235,15 +275,44 @@ func (cm *ClientManager) GetEventClient(insightsInsertKey string, rpmAccountID s
 }
 
 // GetLogClient ...
-func (cm *ClientManager) GetLogClient(insightsInsertKey string, rpmAccountID string, accountRegion string) *logs.Logs {
+func (cm *ClientManager) GetLogClient(insightsInsertKey string, rpmAccountID string, accountRegion string) LogClient {
+	c := cm.getLogClient(insightsInsertKey, rpmAccountID, accountRegion)
+	if p := cm.getPipeline(); p != nil {
+		return &transformedLogs{LogClient: c, pipeline: p}
+	}
+	return c
+}
+
+func (cm *ClientManager) getLogClient(insightsInsertKey string, rpmAccountID string, accountRegion string) LogClient {
+	if c := cm.getSink(); c != nil {
+		return c
+	}
 	if c, ok := cm.HasLogClient(insightsInsertKey); ok {
 		return c
 	}
 	return cm.NewLogClient(insightsInsertKey, rpmAccountID, accountRegion)
 }
 
+func (cm *ClientManager) getPipeline() *transform.Pipeline {
+	cm.sync.RLock()
+	defer cm.sync.RUnlock()
+	return cm.pipeline
+}
+
+func (cm *ClientManager) getSink() *WriterClient {
+	cm.sync.RLock()
+	defer cm.sync.RUnlock()
+	return cm.sink
+}
+
 // FlushAll clients
 func (cm *ClientManager) FlushAll() {
+	if c := cm.getSink(); c != nil {
+		if err := c.Flush(); err != nil {
+			app.Get().Log.Errorf("Unable to flush sink: %v", err)
+		}
+	}
+
 	for _, c := range cm.eCollection {
 		if err := c.Flush(); err != nil {
 			app.Get().Log.Errorf("Unable to flush events: %v", err)
@@ -0,0 +1,34 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrclients
+
+import (
+	"context"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/transform"
+)
+
+// transformedEvents applies the pipeline to events before enqueueing them.
+type transformedEvents struct {
+	EventClient
+	pipeline *transform.Pipeline
+}
+
+// EnqueueEvent ...
+func (c *transformedEvents) EnqueueEvent(ctx context.Context, event interface{}) error {
+	c.pipeline.Apply(event)
+	return c.EventClient.EnqueueEvent(ctx, event)
+}
+
+// transformedLogs applies the pipeline to log entries before enqueueing them.
+type transformedLogs struct {
+	LogClient
+	pipeline *transform.Pipeline
+}
+
+// EnqueueLogEntry ...
+func (c *transformedLogs) EnqueueLogEntry(ctx context.Context, log interface{}) error {
+	c.pipeline.Apply(log)
+	return c.LogClient.EnqueueLogEntry(ctx, log)
+}
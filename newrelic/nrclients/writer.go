@@ -0,0 +1,51 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrclients
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// WriterClient writes events and log entries as JSON lines instead of sending them to New Relic.
+// It satisfies both EventClient and LogClient and is used as the replay sink.
+type WriterClient struct {
+	w    *bufio.Writer
+	enc  *json.Encoder
+	sync *sync.Mutex
+}
+
+// NewWriterClient ...
+func NewWriterClient(w io.Writer) *WriterClient {
+	bw := bufio.NewWriter(w)
+	return &WriterClient{
+		w:    bw,
+		enc:  json.NewEncoder(bw),
+		sync: &sync.Mutex{},
+	}
+}
+
+// EnqueueEvent ...
+func (c *WriterClient) EnqueueEvent(ctx context.Context, event interface{}) error {
+	c.sync.Lock()
+	defer c.sync.Unlock()
+	return c.enc.Encode(event)
+}
+
+// EnqueueLogEntry ...
+func (c *WriterClient) EnqueueLogEntry(ctx context.Context, log interface{}) error {
+	c.sync.Lock()
+	defer c.sync.Unlock()
+	return c.enc.Encode(log)
+}
+
+// Flush ...
+func (c *WriterClient) Flush() error {
+	c.sync.Lock()
+	defer c.sync.Unlock()
+	return c.w.Flush()
+}
@@ -6,11 +6,10 @@ package nrpcf
 import (
 	"net/url"
 	"reflect"
+	"regexp"
 	"strings"
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
-	"github.com/newrelic/newrelic-client-go/pkg/events"
-	"github.com/newrelic/newrelic-client-go/pkg/logs"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/cfapps"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
@@ -64,7 +63,7 @@ func GetPCFAttributes(e *loggregator_v2.Envelope) *attributes.Attributes {
 
 	et := reflect.TypeOf(e.Message).String()
 	if et == "*loggregator_v2.Envelope_Gauge" {
-		if isContainerMetric(e) {
+		if IsContainerMetric(e) {
 			et = "ContainerMetric"
 		} else {
 			et = "ValueMetric"
@@ -88,6 +87,21 @@ func GetPCFAttributes(e *loggregator_v2.Envelope) *attributes.Attributes {
 	return attrs
 }
 
+// GetAppAttributes returns the name, space and org names of the app, for entities
+// aggregating every instance of an app.
+func GetAppAttributes(guid string) *attributes.Attributes {
+	attrs := attributes.NewAttributes()
+	app := cfapps.GetInstance().GetApp(guid)
+	app.Lock.RLock()
+	defer app.Lock.RUnlock()
+	for _, name := range []string{cfapps.AppName, cfapps.AppSpaceName, cfapps.AppOrgName} {
+		if a := app.Attributes.Has(name); a != nil {
+			attrs.Append(a)
+		}
+	}
+	return attrs
+}
+
 // PCFDomain ...
 func PCFDomain() string {
 	u, _ := url.Parse(app.Get().Config.GetString(config.EnvCFAPIRUL))
@@ -152,25 +166,33 @@ func GetLicenseKey(credentials map[string]interface{}) (string, bool) {
 
 // GetInsertClientForApp checks app for newrelic plan sub-account insert creds
 // and return insight client from insert manager/cache or new.
-// If app does not have a plan, this returns the main account credentials (from the config file)
-func GetInsertClientForApp(e *entities.Entity) (c *events.Events) {
+// If app does not have a plan, this returns the account credentials from cfg
+func GetInsertClientForApp(e *entities.Entity, cfg *config.Config) (c nrclients.EventClient) {
 
-	guid := e.AttributeByName(config.Get().AttributeName(config.EnvAppID)).Value()
-	cfapp := cfapps.GetInstance().GetApp(guid.(string))
 	cm := nrclients.New()
+	// Entities without an app, such as the cardinality overflow entity or platform
+	// components, use the account.
+	var guid string
+	if id := e.AttributeByName(config.Get().AttributeName(config.EnvAppID)); id != nil {
+		guid, _ = id.Value().(string)
+	}
+	if !IsAppGUID(guid) {
+		return cm.GetEventClient(cfg.GetNewRelicConfig())
+	}
+	cfapp := cfapps.GetInstance().GetApp(guid)
 
 	cfapp.Lock.RLock()
 	vcap := cfapp.VcapServices
 	cfapp.Lock.RUnlock()
 
 	if vcap == nil {
-		defaultClient := cm.GetEventClient(app.Get().Config.GetNewRelicConfig())
+		defaultClient := cm.GetEventClient(cfg.GetNewRelicConfig())
 		return defaultClient
 	}
 
 	//Can do this if newrelic isn't found, but also need to check for rpmAccountId and insightsInsertKey values
 	if _, found := vcap["newrelic"]; !found {
-		defaultClient := cm.GetEventClient(app.Get().Config.GetNewRelicConfig())
+		defaultClient := cm.GetEventClient(cfg.GetNewRelicConfig())
 		return defaultClient
 	}
 
@@ -179,7 +201,7 @@ func GetInsertClientForApp(e *entities.Entity) (c *events.Events) {
 
 	// Get the credentials map from inside of the newrelic map, if it exists.
 	if _, found := newrelic["credentials"].(map[string]interface{}); !found {
-		defaultClient := cm.GetEventClient(app.Get().Config.GetNewRelicConfig())
+		defaultClient := cm.GetEventClient(cfg.GetNewRelicConfig())
 		return defaultClient
 	}
 	credentials := newrelic["credentials"].(map[string]interface{})
@@ -187,20 +209,20 @@ func GetInsertClientForApp(e *entities.Entity) (c *events.Events) {
 	// Call GetInsertKey
 	insertKey, found := GetInsertKey(credentials)
 	if !found {
-		defaultClient := cm.GetEventClient(app.Get().Config.GetNewRelicConfig())
+		defaultClient := cm.GetEventClient(cfg.GetNewRelicConfig())
 		return defaultClient
 	}
 	// Call GetRpmId
 	rpmId, found := GetRpmId(credentials)
 	if !found {
-		defaultClient := cm.GetEventClient(app.Get().Config.GetNewRelicConfig())
+		defaultClient := cm.GetEventClient(cfg.GetNewRelicConfig())
 		return defaultClient
 	}
 
 	// Call GetLicenseKey
 	licenseKey, found := GetLicenseKey(credentials)
 	if !found {
-		defaultClient := cm.GetEventClient(app.Get().Config.GetNewRelicConfig())
+		defaultClient := cm.GetEventClient(cfg.GetNewRelicConfig())
 		return defaultClient
 	}
 
@@ -221,25 +243,33 @@ func GetInsertClientForApp(e *entities.Entity) (c *events.Events) {
 
 // GetLogClientForApp checks app for newrelic plan sub-account insert creds
 // and return insight client from insert manager/cache or new.
-// If app does not have a plan, this returns the main account credentials (from the config file)
-func GetLogClientForApp(e *entities.Entity) (c *logs.Logs) {
+// If app does not have a plan, this returns the account credentials from cfg
+func GetLogClientForApp(e *entities.Entity, cfg *config.Config) (c nrclients.LogClient) {
 
-	guid := e.AttributeByName(config.Get().AttributeName(config.EnvAppID)).Value()
-	cfapp := cfapps.GetInstance().GetApp(guid.(string))
 	cm := nrclients.New()
+	// Entities without an app, such as the cardinality overflow entity or platform
+	// components, use the account.
+	var guid string
+	if id := e.AttributeByName(config.Get().AttributeName(config.EnvAppID)); id != nil {
+		guid, _ = id.Value().(string)
+	}
+	if !IsAppGUID(guid) {
+		return cm.GetLogClient(cfg.GetNewRelicConfig())
+	}
+	cfapp := cfapps.GetInstance().GetApp(guid)
 
 	cfapp.Lock.RLock()
 	vcap := cfapp.VcapServices
 	cfapp.Lock.RUnlock()
 
 	if vcap == nil {
-		defaultClient := cm.GetLogClient(app.Get().Config.GetNewRelicConfig())
+		defaultClient := cm.GetLogClient(cfg.GetNewRelicConfig())
 		return defaultClient
 	}
 
 	//Can do this if newrelic isn't found, but also need to check for rpmAccountId and insightsInsertKey values
 	if _, found := vcap["newrelic"]; !found {
-		defaultClient := cm.GetLogClient(app.Get().Config.GetNewRelicConfig())
+		defaultClient := cm.GetLogClient(cfg.GetNewRelicConfig())
 		return defaultClient
 	}
 
@@ -248,7 +278,7 @@ func GetLogClientForApp(e *entities.Entity) (c *logs.Logs) {
 
 	// Get the credentials map from inside of the newrelic map, if it exists.
 	if _, found := newrelic["credentials"].(map[string]interface{}); !found {
-		defaultClient := cm.GetLogClient(app.Get().Config.GetNewRelicConfig())
+		defaultClient := cm.GetLogClient(cfg.GetNewRelicConfig())
 		return defaultClient
 	}
 	credentials := newrelic["credentials"].(map[string]interface{})
@@ -256,20 +286,20 @@ func GetLogClientForApp(e *entities.Entity) (c *logs.Logs) {
 	// Call GetInsertKey
 	insertKey, found := GetInsertKey(credentials)
 	if !found {
-		defaultClient := cm.GetLogClient(app.Get().Config.GetNewRelicConfig())
+		defaultClient := cm.GetLogClient(cfg.GetNewRelicConfig())
 		return defaultClient
 	}
 	// Call GetRpmId
 	rpmId, found := GetRpmId(credentials)
 	if !found {
-		defaultClient := cm.GetLogClient(app.Get().Config.GetNewRelicConfig())
+		defaultClient := cm.GetLogClient(cfg.GetNewRelicConfig())
 		return defaultClient
 	}
 
 	// Call GetLicenseKey
 	licenseKey, found := GetLicenseKey(credentials)
 	if !found {
-		defaultClient := cm.GetLogClient(app.Get().Config.GetNewRelicConfig())
+		defaultClient := cm.GetLogClient(cfg.GetNewRelicConfig())
 		return defaultClient
 	}
 
@@ -288,21 +318,70 @@ func GetLogClientForApp(e *entities.Entity) (c *logs.Logs) {
 
 }
 
-// isContainerMetric determines if the current v2 Gauge envelope is a v1 ContainerMetric or v1 ValueMetric
-func isContainerMetric(e *loggregator_v2.Envelope) bool {
+// classicContainerMetrics are the gauges of a v1 ContainerMetric.
+var classicContainerMetrics = []string{
+	"cpu",
+	"memory",
+	"disk",
+	"memory_quota",
+	"disk_quota",
+}
+
+// containerMetrics are every gauge Diego emits for an app instance. Newer Diego versions
+// send the entitlement, age and log rate gauges in envelopes of their own.
+var containerMetrics = map[string]bool{
+	"cpu":                  true,
+	"memory":               true,
+	"disk":                 true,
+	"memory_quota":         true,
+	"disk_quota":           true,
+	"cpu_entitlement":      true,
+	"container_age":        true,
+	"log_rate":             true,
+	"log_rate_limit":       true,
+	"absolute_usage":       true,
+	"absolute_entitlement": true,
+	"anomalous_delay":      true,
+}
+
+// guid of the apps sending container metrics as their source ID.
+var guid = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// IsAppGUID reports whether the source ID is an app GUID, platform components such as
+// gorouter use their name.
+func IsAppGUID(sourceID string) bool {
+	return guid.MatchString(sourceID)
+}
+
+// fromApp reports whether the envelope was sent for an app, with an app GUID as source ID
+// or the app tags.
+func fromApp(e *loggregator_v2.Envelope) bool {
+	if IsAppGUID(e.GetSourceId()) {
+		return true
+	}
+	_, hasID := e.GetTags()["app_id"]
+	_, hasName := e.GetTags()["app_name"]
+	return hasID || hasName
+}
+
+// IsContainerMetric determines if the current v2 Gauge envelope is a v1 ContainerMetric or v1 ValueMetric.
+// Envelopes of an app with the classic ContainerMetric gauges, or with an instance ID and
+// only container gauges, are container metrics. Platform components emitting gauges with
+// the same names, e.g. memory, are value metrics.
+func IsContainerMetric(e *loggregator_v2.Envelope) bool {
 	gauge := e.GetGauge()
-	if len(gauge.Metrics) != 5 {
+	if len(gauge.GetMetrics()) == 0 || !fromApp(e) {
 		return false
 	}
-	required := []string{
-		"cpu",
-		"memory",
-		"disk",
-		"memory_quota",
-		"disk_quota",
+	for name := range gauge.Metrics {
+		if !containerMetrics[name] {
+			return false
+		}
+	}
+	if e.GetInstanceId() != "" {
+		return true
 	}
-
-	for _, req := range required {
+	for _, req := range classicContainerMetrics {
 		if _, found := gauge.Metrics[req]; !found {
 			return false
 		}
@@ -0,0 +1,52 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrpcf
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func gauge(instance string, names ...string) *loggregator_v2.Envelope {
+	g := &loggregator_v2.Gauge{Metrics: map[string]*loggregator_v2.GaugeValue{}}
+	for _, n := range names {
+		g.Metrics[n] = &loggregator_v2.GaugeValue{Value: 1}
+	}
+	return &loggregator_v2.Envelope{
+		SourceId:   "6f5a0d1c-2c0e-4f9f-9b55-1f5e0a6b8b11",
+		InstanceId: instance,
+		Message:    &loggregator_v2.Envelope_Gauge{Gauge: g},
+	}
+}
+
+func TestIsContainerMetric(t *testing.T) {
+	assert.True(t, IsContainerMetric(gauge("", "cpu", "memory", "disk", "memory_quota", "disk_quota")))
+	assert.True(t, IsContainerMetric(gauge("0", "cpu", "memory", "disk", "memory_quota", "disk_quota", "cpu_entitlement")))
+	assert.True(t, IsContainerMetric(gauge("1", "absolute_usage", "absolute_entitlement", "container_age")))
+	assert.True(t, IsContainerMetric(gauge("2", "log_rate", "log_rate_limit")))
+	assert.True(t, IsContainerMetric(gauge("0", "anomalous_delay")))
+
+	// Without an instance ID only the classic set is a container metric.
+	assert.False(t, IsContainerMetric(gauge("", "log_rate", "log_rate_limit")))
+	// Any gauge outside the container set is a value metric.
+	assert.False(t, IsContainerMetric(gauge("0", "cpu", "numCPUS")))
+	assert.False(t, IsContainerMetric(gauge("0")))
+
+	// Only apps send container metrics, identified by their GUID or app tags.
+	component := gauge("0", "memory", "disk")
+	component.SourceId = "doppler"
+	assert.False(t, IsContainerMetric(component))
+	component.Tags = map[string]string{"app_id": "6f5a0d1c-2c0e-4f9f-9b55-1f5e0a6b8b11"}
+	assert.True(t, IsContainerMetric(component))
+	component.Tags = map[string]string{"app_name": "store"}
+	assert.True(t, IsContainerMetric(component))
+}
+
+func TestIsAppGUID(t *testing.T) {
+	assert.True(t, IsAppGUID("6f5a0d1c-2c0e-4f9f-9b55-1f5e0a6b8b11"))
+	assert.False(t, IsAppGUID("gorouter"))
+	assert.False(t, IsAppGUID(""))
+}
@@ -1,25 +1,138 @@
 // Copyright 2020 New Relic Corporation. All rights reserved.
 // SPDX-License-Identifier: Apache-2.0
 
+// Package registry holds the accumulator kinds the nozzle can run. ACCUMULATORS selects
+// the instances: a kind, or kind:name to run another instance of a kind with the
+// settings overridden by NRF_ACC_<NAME>_<SETTING> environment variables.
+//
+// Accumulators outside this repository register a kind from an init function
+// and are linked in with a blank import in main:
+//
+//	func init() {
+//		registry.Register("mykind", mykind.Nrevents{})
+//	}
 package registry
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/accumulators/container"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/accumulators/counter"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/accumulators/event"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/accumulators/http"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/accumulators/logmessage"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/accumulators/value"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/accumulators"
 )
 
 // Accumulators ...
 type Accumulators []accumulators.Interface
 
-// Register ...
-var Register = &Accumulators{
-	counter.Metrics{},
-	container.Metrics{},
-	value.Metrics{},
-	logmessage.Nrevents{},
-	http.Nrevents{},
+var (
+	kinds = map[string]accumulators.Interface{}
+	lock  = &sync.RWMutex{}
+)
+
+func init() {
+	Register("counter", counter.Metrics{})
+	Register("container", container.Metrics{})
+	Register("value", value.Metrics{})
+	Register("logmessage", logmessage.Nrevents{})
+	Register("http", http.Nrevents{})
+	Register("event", event.Nrevents{})
+}
+
+// Register an accumulator kind. New is called on a for every instance of the kind.
+// Registering the same kind twice panics.
+func Register(kind string, a accumulators.Interface) {
+	lock.Lock()
+	defer lock.Unlock()
+	kind = strings.ToLower(kind)
+	if _, found := kinds[kind]; found {
+		panic(fmt.Sprintf("accumulator kind %s is already registered", kind))
+	}
+	kinds[kind] = a
+}
+
+// Kinds returns the registered accumulator kinds.
+func Kinds() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+	return kindNames()
+}
+
+// Instance of an accumulator kind.
+type Instance struct {
+	Name string
+	Kind string
+}
+
+// Instances parses ACCUMULATORS, leaving out the names in ACCUMULATORS_DISABLE.
+func Instances(c *config.Config) ([]Instance, error) {
+	disabled := map[string]bool{}
+	for _, name := range c.GetFilter("ACCUMULATORS_DISABLE") {
+		disabled[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+
+	var instances []Instance
+	names := map[string]bool{}
+	for _, s := range c.GetFilter("ACCUMULATORS") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" {
+			continue
+		}
+		i := Instance{Name: s, Kind: s}
+		if parts := strings.SplitN(s, ":", 2); len(parts) == 2 {
+			i = Instance{Kind: parts[0], Name: parts[1]}
+		}
+		if names[i.Name] {
+			return nil, fmt.Errorf("duplicate accumulator name %s", i.Name)
+		}
+		names[i.Name] = true
+		if disabled[i.Name] {
+			continue
+		}
+		instances = append(instances, i)
+	}
+	return instances, nil
+}
+
+// New creates the accumulator instances selected by ACCUMULATORS. Instances named
+// after their kind use c, others use c scoped to their name.
+func New(c *config.Config) (*Accumulators, error) {
+	instances, err := Instances(c)
+	if err != nil {
+		return nil, err
+	}
+
+	lock.RLock()
+	defer lock.RUnlock()
+	r := Accumulators{}
+	for _, i := range instances {
+		a, found := kinds[i.Kind]
+		if !found {
+			return nil, fmt.Errorf("unknown accumulator kind %s, registered kinds are %s", i.Kind, strings.Join(kindNames(), ", "))
+		}
+		cfg := c
+		if i.Name != i.Kind {
+			cfg = c.Scoped(i.Name)
+		}
+		if _, err := cfg.GetPercentiles(); err != nil {
+			return nil, fmt.Errorf("accumulator %s: %s", i.Name, err.Error())
+		}
+		r = append(r, a.New(cfg))
+	}
+	return &r, nil
+}
+
+func kindNames() (k []string) {
+	for kind := range kinds {
+		k = append(k, kind)
+	}
+	sort.Strings(k)
+	return k
 }
@@ -0,0 +1,70 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registry
+
+import (
+	"os"
+	"testing"
+
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstances(t *testing.T) {
+	os.Setenv("NRF_ACCUMULATORS", "counter|logmessage| logmessage:audit|http")
+	os.Setenv("NRF_ACCUMULATORS_DISABLE", "http")
+	defer os.Unsetenv("NRF_ACCUMULATORS")
+	defer os.Unsetenv("NRF_ACCUMULATORS_DISABLE")
+
+	instances, err := Instances(config.Get())
+	assert.NoError(t, err)
+	assert.Equal(t, []Instance{
+		{Name: "counter", Kind: "counter"},
+		{Name: "logmessage", Kind: "logmessage"},
+		{Name: "audit", Kind: "logmessage"},
+	}, instances)
+
+	os.Setenv("NRF_ACCUMULATORS", "logmessage|value:logmessage")
+	_, err = Instances(config.Get())
+	assert.Error(t, err)
+}
+
+func TestScopedSettings(t *testing.T) {
+	os.Setenv("NRF_LOGMESSAGE_SOURCE_INCLUDE", "APP")
+	os.Setenv("NRF_ACC_AUDIT_LOGMESSAGE_SOURCE_INCLUDE", "RTR")
+	defer os.Unsetenv("NRF_LOGMESSAGE_SOURCE_INCLUDE")
+	defer os.Unsetenv("NRF_ACC_AUDIT_LOGMESSAGE_SOURCE_INCLUDE")
+
+	c := config.Get()
+	assert.Equal(t, "APP", c.GetString("LOGMESSAGE_SOURCE_INCLUDE"))
+	assert.Equal(t, "RTR", c.Scoped("audit").GetString("LOGMESSAGE_SOURCE_INCLUDE"))
+	assert.Equal(t, c.GetString("FIREHOSE_ID"), c.Scoped("audit").GetString("FIREHOSE_ID"))
+}
+
+func TestScopedSettingsDoNotMatchOtherSettings(t *testing.T) {
+	os.Setenv("NRF_ROUTER_WORKERS", "3")
+	defer os.Unsetenv("NRF_ROUTER_WORKERS")
+
+	c := config.Get()
+	assert.Equal(t, 3, c.GetInt("ROUTER_WORKERS"))
+	assert.False(t, c.Scoped("router").IsSet("WORKERS"))
+	assert.Equal(t, 3, c.Scoped("router").GetInt("ROUTER_WORKERS"))
+}
+
+func TestRegisterDuplicateKind(t *testing.T) {
+	assert.Contains(t, Kinds(), "logmessage")
+	assert.Panics(t, func() { Register("LogMessage", nil) })
+}
+
+func TestNewRejectsInvalidScopedPercentiles(t *testing.T) {
+	os.Setenv("NRF_ACCUMULATORS", "counter:bad")
+	os.Setenv("NRF_ACC_BAD_METRICS_PERCENTILES", "50|101")
+	defer os.Unsetenv("NRF_ACCUMULATORS")
+	defer os.Unsetenv("NRF_ACC_BAD_METRICS_PERCENTILES")
+
+	_, err := New(config.Get())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "accumulator bad")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"errors"
+	"os"
+
+	"code.cloudfoundry.org/go-diodes"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/cfclient/cfapps"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/firehose"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrclients"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/registry"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/transform"
+)
+
+// ReplayOptions ...
+type ReplayOptions struct {
+	// File is a firehose recording, see firehose.Recorder
+	File string
+	// Speed multiplies the original envelope rate, 0 replays as fast as possible
+	Speed float64
+	// Sink is "newrelic" for the configured account, "stdout", or a file path
+	// receiving one JSON event or log entry per line.
+	Sink string
+}
+
+// Replay feeds a firehose recording through the Router, accumulators and the chosen sink
+// without a live foundation. CF app details are not fetched.
+func Replay(o ReplayOptions) error {
+
+	app := app.Get()
+
+	switch o.Sink {
+	case "", "newrelic":
+		if key, id, _ := app.Config.GetNewRelicConfig(); key == "" || id == "" {
+			return errors.New("the newrelic sink requires NRF_NEWRELIC_INSERT_KEY and NRF_NEWRELIC_ACCOUNT_ID")
+		}
+	case "stdout":
+		nrclients.New().SetSink(nrclients.NewWriterClient(os.Stdout))
+	default:
+		f, err := os.Create(o.Sink)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		nrclients.New().SetSink(nrclients.NewWriterClient(f))
+	}
+
+	cfapps.StartOffline(app)
+	pipeline, err := transform.New(app.Config.GetString("TRANSFORM_RULES"), app.Config.GetString("REDACT_HASH_KEY"))
+	if err != nil {
+		return err
+	}
+	nrclients.New().SetPipeline(pipeline)
+	accumulators, err := registry.New(app.Config)
+	if err != nil {
+		return err
+	}
+	collector := NewCollector(accumulators)
+	router := NewRouter(
+		firehose.NewOneToOneEnvelope(
+			app.Config.GetInt("FIREHOSE_DIODE_BUFFER"),
+			diodes.AlertFunc(func(missed int) {}),
+		),
+		collector,
+	)
+	router.Start()
+	harvester := NewHarvester(collector)
+	harvest := harvestConfig(app)
+	defer harvest.Stop()
+
+	app.Log.Infof("replaying %s at speed %v", o.File, o.Speed)
+
+	var count int
+	done := make(chan error)
+	go func() {
+		// Envelopes are dispatched straight to the router workers, which block
+		// instead of dropping envelopes when they fall behind.
+		var err error
+		count, err = firehose.Replay(o.File, o.Speed, router.Dispatch)
+		done <- err
+	}()
+
+	for {
+		select {
+
+		case err := <-done:
+			router.Close()
+			app.WaitGroup.Wait()
+			collector.Flush()
+			harvester.Harvest()
+			app.Log.Infof("replayed %d envelopes", count)
+			return err
+
+		case <-harvest.C:
+			harvester.Harvest()
+		}
+	}
+}
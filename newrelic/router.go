@@ -4,14 +4,27 @@
 package newrelic
 
 import (
-	"reflect"
+	"context"
+	"hash/fnv"
+	"runtime"
 	"strings"
-	"time"
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/app"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/firehose"
 	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/accumulators"
+	"github.com/newrelic/newrelic-pcf-nozzle-tile/newrelic/nrpcf"
+)
+
+// Stream names accumulators register for. Gauge envelopes are split into the
+// v1 ContainerMetric and ValueMetric types.
+const (
+	StreamCounter         = "*loggregator_v2.Envelope_Counter"
+	StreamLog             = "*loggregator_v2.Envelope_Log"
+	StreamTimer           = "*loggregator_v2.Envelope_Timer"
+	StreamEvent           = "*loggregator_v2.Envelope_Event"
+	StreamContainerMetric = "ContainerMetric"
+	StreamValueMetric     = "ValueMetric"
 )
 
 // Streams ...
@@ -25,19 +38,19 @@ type Router struct {
 	Collector *Collector
 	ErrorChan chan error
 	closeChan chan bool
-	firehose  *firehose.Firehose
+	workers   []chan *loggregator_v2.Envelope
+	cancel    context.CancelFunc
 }
 
-// NewRouter with Firehose
-func NewRouter(f *firehose.Firehose, c *Collector) *Router {
+// NewRouter consuming envelopes from the Firehose queue
+func NewRouter(q *firehose.OneToOneEnvelope, c *Collector) *Router {
 	router := &Router{
 		App:       app.Get(),
-		Consumer:  f.Queue,
+		Consumer:  q,
 		Streams:   Streams{},
 		Collector: c,
 		ErrorChan: make(chan error, 1),
 		closeChan: make(chan bool, 1),
-		firehose:  f,
 	}
 	// These are the possible event type names:
 	// 		*loggregator_v2.Envelope_Counter
@@ -45,6 +58,7 @@ func NewRouter(f *firehose.Firehose, c *Collector) *Router {
 	// 		ValueMetric
 	// 		*loggregator_v2.Envelope_Log
 	// 		*loggregator_v2.Envelope_Timer
+	// 		*loggregator_v2.Envelope_Event
 	for _, a := range *router.Collector.accumulators {
 		for _, s := range a.Streams() {
 			for _, t := range router.App.Config.GetNewEnvelopeTypes() {
@@ -59,19 +73,44 @@ func NewRouter(f *firehose.Firehose, c *Collector) *Router {
 
 // Close Router
 func (r *Router) Close() {
+	r.cancel()
 	r.closeChan <- true
 }
 
 // Start Router
 func (r *Router) Start() {
 
-	// consume from firehose and route synchronously
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	count := r.App.Config.GetInt("ROUTER_WORKERS")
+	if count < 1 {
+		count = runtime.NumCPU()
+	}
+	r.workers = make([]chan *loggregator_v2.Envelope, count)
+	for i := range r.workers {
+		r.workers[i] = make(chan *loggregator_v2.Envelope, r.App.Config.GetInt("ROUTER_WORKER_BUFFER"))
+		r.App.WaitGroup.Add(1)
+		go r.work(r.workers[i])
+	}
+
+	// consume from firehose, blocking until envelopes are available, and shard to the workers
 	go func() {
-		r.App.Log.Info("router started")
-		// Track the number of consecutive empty diodes
-		ed := 0
+		r.App.Log.Infof("router started with %d worker(s)", count)
 		for {
+			e := r.Consumer.Next(ctx)
+			if e == nil {
+				for _, w := range r.workers {
+					close(w)
+				}
+				return
+			}
+			r.Dispatch(e)
+		}
+	}()
 
+	go func() {
+		for {
 			select {
 
 			case <-r.closeChan:
@@ -79,62 +118,73 @@ func (r *Router) Start() {
 				return
 
 			case err := <-r.ErrorChan:
-				r.App.Log.Errorf("Router error: %e", err.Error())
-
-			default:
-				if e, notEmpty := r.Consumer.TryNext(); notEmpty {
-					// Reset the emptyDiodes count.  We found an envelope.
-					ed = 0
-
-					et := reflect.TypeOf(e.Message).String()
-					if et == "*loggregator_v2.Envelope_Gauge" {
-						if isContainerMetric(e) {
-							et = "ContainerMetric"
-						} else {
-							et = "ValueMetric"
-						}
-					}
-
-					for _, a := range r.Streams[et] {
-						r.App.Log.Tracer(">")
-						a.Update(e)
-					}
-					continue
-				}
-				r.App.Log.Tracer("o")
-				ed++
-				// Have the diodes been empty for > threshold?  Multiplying by 2 due to 500ms sleep
-				if ed > (r.App.Config.GetInt("FIREHOSE_RESTART_THRESH_SECS") * 2) {
-					// Reset the emptyDiodes counter so we don't constanty restart the nozzle every 500ms
-					ed = 0
-					r.App.Log.Warnf("Diodes have been empty for > %v seconds.  Restarting firehose nozzle.", r.App.Config.GetInt("FIREHOSE_RESTART_THRESH_SECS"))
-					r.firehose.RestartNozzle()
-				}
-				time.Sleep(500 * time.Millisecond)
+				r.App.Log.Errorf("Router error: %s", err.Error())
 			}
 		}
 	}()
 
 }
 
-// isContainerMetric determines if the current v2 Gauge envelope is a v1 ContainerMetric or v1 ValueMetric
-func isContainerMetric(e *loggregator_v2.Envelope) bool {
-	gauge := e.GetGauge()
-	if len(gauge.Metrics) != 5 {
-		return false
+// Dispatch hands the envelope to its worker, blocking while the worker queue is full.
+func (r *Router) Dispatch(e *loggregator_v2.Envelope) {
+	r.workers[r.shard(e)] <- e
+}
+
+// work routes envelopes from a single worker queue until it is closed.
+func (r *Router) work(queue <-chan *loggregator_v2.Envelope) {
+	defer r.App.WaitGroup.Done()
+	for e := range queue {
+		r.Route(e)
+	}
+}
+
+// Route sends the envelope to every accumulator registered for its stream.
+func (r *Router) Route(e *loggregator_v2.Envelope) {
+	for _, a := range r.Streams[envelopeStream(e)] {
+		r.App.Log.Tracer(">")
+		a.Update(e)
+	}
+}
+
+// shard picks a worker from the envelope fields that make up an entity signature, so the
+// same entity is always updated by the same worker and accumulators stay consistent.
+func (r *Router) shard(e *loggregator_v2.Envelope) int {
+	if len(r.workers) == 1 {
+		return 0
 	}
-	required := []string{
-		"cpu",
-		"memory",
-		"disk",
-		"memory_quota",
-		"disk_quota",
+	h := fnv.New32a()
+	for _, v := range []string{
+		envelopeStream(e),
+		e.GetSourceId(),
+		e.GetInstanceId(),
+		e.Tags["origin"],
+		e.Tags["deployment"],
+		e.Tags["job"],
+		e.Tags["index"],
+		e.Tags["ip"],
+	} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
 	}
+	return int(h.Sum32() % uint32(len(r.workers)))
+}
 
-	for _, req := range required {
-		if _, found := gauge.Metrics[req]; !found {
-			return false
+// envelopeStream returns the stream name accumulators register for the envelope's message type.
+func envelopeStream(e *loggregator_v2.Envelope) string {
+	switch e.Message.(type) {
+	case *loggregator_v2.Envelope_Counter:
+		return StreamCounter
+	case *loggregator_v2.Envelope_Gauge:
+		if nrpcf.IsContainerMetric(e) {
+			return StreamContainerMetric
 		}
+		return StreamValueMetric
+	case *loggregator_v2.Envelope_Log:
+		return StreamLog
+	case *loggregator_v2.Envelope_Timer:
+		return StreamTimer
+	case *loggregator_v2.Envelope_Event:
+		return StreamEvent
 	}
-	return true
+	return ""
 }
@@ -0,0 +1,91 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"fmt"
+	"testing"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/stretchr/testify/assert"
+)
+
+const appGUID = "6f5a0d1c-2c0e-4f9f-9b55-1f5e0a6b8b11"
+
+func TestEnvelopeStream(t *testing.T) {
+	gauge := func(source string, instance string, names ...string) *loggregator_v2.Envelope {
+		metrics := map[string]*loggregator_v2.GaugeValue{}
+		for _, name := range names {
+			metrics[name] = &loggregator_v2.GaugeValue{Value: 1}
+		}
+		return &loggregator_v2.Envelope{
+			SourceId:   source,
+			InstanceId: instance,
+			Message:    &loggregator_v2.Envelope_Gauge{Gauge: &loggregator_v2.Gauge{Metrics: metrics}},
+		}
+	}
+	for _, c := range []struct {
+		envelope *loggregator_v2.Envelope
+		stream   string
+	}{
+		{&loggregator_v2.Envelope{Message: &loggregator_v2.Envelope_Counter{Counter: &loggregator_v2.Counter{}}}, StreamCounter},
+		{&loggregator_v2.Envelope{Message: &loggregator_v2.Envelope_Log{Log: &loggregator_v2.Log{}}}, StreamLog},
+		{&loggregator_v2.Envelope{Message: &loggregator_v2.Envelope_Timer{Timer: &loggregator_v2.Timer{}}}, StreamTimer},
+		{&loggregator_v2.Envelope{Message: &loggregator_v2.Envelope_Event{Event: &loggregator_v2.Event{}}}, StreamEvent},
+		{gauge(appGUID, "0", "cpu", "memory"), StreamContainerMetric},
+		{gauge(appGUID, "0", "cpu", "requests"), StreamValueMetric},
+		{gauge("gorouter", "0", "cpu"), StreamValueMetric},
+		{&loggregator_v2.Envelope{}, ""},
+	} {
+		assert.Equal(t, c.stream, envelopeStream(c.envelope), fmt.Sprintf("%v", c.envelope.GetMessage()))
+	}
+}
+
+func TestDispatchKeepsEntitiesOnOneWorker(t *testing.T) {
+	r := &Router{workers: make([]chan *loggregator_v2.Envelope, 8)}
+	for i := range r.workers {
+		r.workers[i] = make(chan *loggregator_v2.Envelope, 100)
+	}
+	counter := func(source string, instance string, total uint64) *loggregator_v2.Envelope {
+		return &loggregator_v2.Envelope{
+			SourceId:   source,
+			InstanceId: instance,
+			Timestamp:  int64(total),
+			Tags:       map[string]string{"origin": "rep", "deployment": "cf", "job": "diego-cell", "index": "0", "ip": "10.0.0.1"},
+			Message:    &loggregator_v2.Envelope_Counter{Counter: &loggregator_v2.Counter{Name: "requests", Total: total}},
+		}
+	}
+
+	// Envelopes of the same entity go to the same worker, in order.
+	for total := uint64(1); total <= 20; total++ {
+		r.Dispatch(counter(appGUID, "0", total))
+	}
+	used := 0
+	for _, w := range r.workers {
+		if len(w) == 0 {
+			continue
+		}
+		used++
+		for total := uint64(1); total <= 20; total++ {
+			assert.Equal(t, total, (<-w).GetCounter().GetTotal())
+		}
+	}
+	assert.Equal(t, 1, used)
+
+	// Other entities are spread over the workers.
+	for i := 0; i < 64; i++ {
+		r.Dispatch(counter(fmt.Sprintf("source-%d", i), "0", 1))
+	}
+	used = 0
+	for _, w := range r.workers {
+		if len(w) > 0 {
+			used++
+		}
+	}
+	assert.True(t, used > 1)
+
+	// A single worker takes every envelope.
+	r = &Router{workers: []chan *loggregator_v2.Envelope{make(chan *loggregator_v2.Envelope, 1)}}
+	assert.Equal(t, 0, r.shard(counter(appGUID, "1", 1)))
+}
@@ -0,0 +1,251 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transform rewrites the attributes of events and log entries before they are sent,
+// see Pipeline.
+package transform
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LogType is the event type log entries, which have no eventType attribute, are matched as.
+const LogType = "Log"
+
+// Pipeline applies rules, in order, to the attributes of each event. Rules are | separated,
+// optionally prefixed with a glob of the event types they apply to:
+//
+//	PCFLogMessage:rename:log.message=message|drop:pcf.IP|PCF*:add:team=payments
+//
+// Actions are:
+//
+//	rename:from=to    renames the attribute, from* = to* renames every attribute with the prefix
+//	drop:glob         removes the attributes matching the glob
+//	copy:from=to      copies the attribute
+//	hash:glob         replaces the values with a short hash keyed by REDACT_HASH_KEY, see Hash
+//	add:name=value    sets a static string value
+//	convert:glob=type converts the values to int, float, string or bool
+type Pipeline struct {
+	rules []*rule
+}
+
+type rule struct {
+	eventType string
+	action    string
+	from      string
+	to        string
+	key       string
+}
+
+var actions = map[string]bool{
+	"rename":  true,
+	"drop":    true,
+	"copy":    true,
+	"hash":    true,
+	"add":     true,
+	"convert": true,
+}
+
+// actionsWithValue take from=to arguments.
+var actionsWithValue = map[string]bool{
+	"rename":  true,
+	"copy":    true,
+	"add":     true,
+	"convert": true,
+}
+
+// New Pipeline from the | separated rules, nil when there are none. Hashed values are
+// keyed by key.
+func New(rules string, key string) (*Pipeline, error) {
+	p := &Pipeline{}
+	for _, entry := range strings.Split(rules, "|") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		r, err := parseRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		if r.action == "hash" {
+			if key == "" {
+				return nil, fmt.Errorf("invalid transform rule %q: hash needs NRF_REDACT_HASH_KEY", entry)
+			}
+			r.key = key
+		}
+		p.rules = append(p.rules, r)
+	}
+	if len(p.rules) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+func parseRule(entry string) (*rule, error) {
+	r := &rule{eventType: "*"}
+	parts := strings.SplitN(entry, ":", 2)
+	if !actions[parts[0]] {
+		r.eventType = parts[0]
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid transform rule %q: want [eventType:]action:arguments", entry)
+		}
+		parts = strings.SplitN(parts[1], ":", 2)
+	}
+	if !actions[parts[0]] || len(parts) < 2 || parts[1] == "" {
+		return nil, fmt.Errorf("invalid transform rule %q: want [eventType:]action:arguments", entry)
+	}
+	r.action, r.from = parts[0], parts[1]
+	if actionsWithValue[r.action] {
+		i := strings.Index(r.from, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid transform rule %q: %s wants name=value", entry, r.action)
+		}
+		r.from, r.to = r.from[:i], r.from[i+1:]
+	}
+	if r.action == "convert" && r.to != "int" && r.to != "float" && r.to != "string" && r.to != "bool" {
+		return nil, fmt.Errorf("invalid transform rule %q: convert to int, float, string or bool", entry)
+	}
+	if r.action == "rename" && strings.HasSuffix(r.from, "*") != strings.HasSuffix(r.to, "*") {
+		return nil, fmt.Errorf("invalid transform rule %q: rename a prefix with from*=to*", entry)
+	}
+	for _, glob := range []string{r.eventType, r.from} {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid transform rule %q: %s", entry, err.Error())
+		}
+	}
+	return r, nil
+}
+
+// Apply the rules to the event, a map or a pointer to a map of attributes. Other events
+// and a nil Pipeline leave the event as is.
+func (p *Pipeline) Apply(event interface{}) {
+	if p == nil {
+		return
+	}
+	var attrs map[string]interface{}
+	switch e := event.(type) {
+	case map[string]interface{}:
+		attrs = e
+	case *map[string]interface{}:
+		if e == nil {
+			return
+		}
+		attrs = *e
+	default:
+		return
+	}
+	eventType := LogType
+	if t, ok := attrs["eventType"].(string); ok {
+		eventType = t
+	}
+	for _, r := range p.rules {
+		if matched, _ := path.Match(r.eventType, eventType); matched {
+			r.apply(attrs)
+		}
+	}
+}
+
+func (r *rule) apply(attrs map[string]interface{}) {
+	switch r.action {
+	case "rename":
+		if !strings.HasSuffix(r.from, "*") {
+			if v, ok := attrs[r.from]; ok {
+				delete(attrs, r.from)
+				attrs[r.to] = v
+			}
+			return
+		}
+		from, to := strings.TrimSuffix(r.from, "*"), strings.TrimSuffix(r.to, "*")
+		for _, name := range names(attrs) {
+			if strings.HasPrefix(name, from) {
+				v := attrs[name]
+				delete(attrs, name)
+				attrs[to+strings.TrimPrefix(name, from)] = v
+			}
+		}
+	case "drop":
+		for _, name := range r.matching(attrs) {
+			delete(attrs, name)
+		}
+	case "copy":
+		if v, ok := attrs[r.from]; ok {
+			attrs[r.to] = v
+		}
+	case "hash":
+		for _, name := range r.matching(attrs) {
+			attrs[name] = Hash(r.key, fmt.Sprint(attrs[name]))
+		}
+	case "add":
+		attrs[r.from] = r.to
+	case "convert":
+		for _, name := range r.matching(attrs) {
+			if v, ok := convert(attrs[name], r.to); ok {
+				attrs[name] = v
+			}
+		}
+	}
+}
+
+// matching attribute names of the from glob.
+func (r *rule) matching(attrs map[string]interface{}) []string {
+	if _, ok := attrs[r.from]; ok {
+		return []string{r.from}
+	}
+	var matched []string
+	for _, name := range names(attrs) {
+		if ok, _ := path.Match(r.from, name); ok {
+			matched = append(matched, name)
+		}
+	}
+	return matched
+}
+
+// names of the attributes, sorted so renames are applied in a stable order.
+func names(attrs map[string]interface{}) []string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Hash returns a short HMAC-SHA256 of the value keyed by key, so values can still be
+// correlated without being guessed from a dictionary of likely values.
+func Hash(key string, value string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// convert the value to the type, reporting whether it could be.
+func convert(value interface{}, to string) (interface{}, bool) {
+	s := fmt.Sprint(value)
+	switch to {
+	case "int":
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, true
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return int64(f), true
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, true
+		}
+	case "bool":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b, true
+		}
+	case "string":
+		return s, true
+	}
+	return value, false
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package transform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeline(t *testing.T) {
+	p, err := New("PCFLogMessage:rename:log.message=message | rename:log.json.*=json.* | drop:pcf.I* | "+
+		"copy:app.name=service.name | hash:user.id | PCF*:add:team=payments | convert:status=int | Log:drop:team", "k1")
+	assert.NoError(t, err)
+
+	event := map[string]interface{}{
+		"eventType":      "PCFLogMessage",
+		"log.message":    "hello",
+		"log.json.level": "info",
+		"pcf.IP":         "10.0.0.1",
+		"pcf.Index":      "0",
+		"app.name":       "orders",
+		"user.id":        "bob",
+		"status":         "200",
+	}
+	p.Apply(event)
+	assert.Equal(t, map[string]interface{}{
+		"eventType":    "PCFLogMessage",
+		"message":      "hello",
+		"json.level":   "info",
+		"app.name":     "orders",
+		"service.name": "orders",
+		"user.id":      "c122de3dad4ff809",
+		"team":         "payments",
+		"status":       int64(200),
+	}, event)
+
+	// Metrics are marshaled as pointers to maps, log entries have no event type.
+	metric := &map[string]interface{}{"eventType": "PCFValueMetric", "log.message": "kept"}
+	p.Apply(metric)
+	assert.Equal(t, "kept", (*metric)["log.message"])
+	assert.Equal(t, "payments", (*metric)["team"])
+	log := map[string]interface{}{"message": "hello"}
+	p.Apply(log)
+	assert.NotContains(t, log, "team")
+
+	var none *Pipeline
+	none.Apply(event)
+}
+
+func TestNew(t *testing.T) {
+	p, err := New(" ", "")
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	for _, rules := range []string{"rename", "rename:a", "PCFLogMessage:explode:a", "add:=b", "convert:a=date", "rename:a*=b", "drop:[a"} {
+		_, err := New(rules, "k1")
+		assert.Error(t, err, rules)
+	}
+
+	// Hashes need a key.
+	_, err = New("hash:user.id", "")
+	assert.Error(t, err)
+}
+
+func TestHash(t *testing.T) {
+	assert.Equal(t, "c122de3dad4ff809", Hash("k1", "bob"))
+	assert.NotEqual(t, Hash("k1", "bob"), Hash("k2", "bob"))
+}
+
+func TestConvert(t *testing.T) {
+	v, ok := convert("1.5", "float")
+	assert.True(t, ok)
+	assert.Equal(t, 1.5, v)
+	v, ok = convert(2.9, "int")
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), v)
+	v, ok = convert("true", "bool")
+	assert.True(t, ok)
+	assert.Equal(t, true, v)
+	v, ok = convert(int64(3), "string")
+	assert.True(t, ok)
+	assert.Equal(t, "3", v)
+	v, ok = convert("n/a", "int")
+	assert.False(t, ok)
+	assert.Equal(t, "n/a", v)
+}